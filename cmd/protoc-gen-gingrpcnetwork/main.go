@@ -0,0 +1,185 @@
+// protoc-gen-gingrpcnetwork 为每个服务生成带类型的处理接口和注册函数
+//
+//	protoc --go_out=. --go-grpc_out=. --gingrpcnetwork_out=. xxx.proto
+//
+// 生成的代码依赖 protoc-gen-go-grpc 生成的 Xxx_ServiceDesc 和流接口
+package main
+
+import (
+	"flag"
+	"fmt"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+	"strings"
+)
+
+const version = "0.1.0"
+
+const (
+	contextPackage = protogen.GoImportPath("context")
+	grpcPackage    = protogen.GoImportPath("google.golang.org/grpc")
+	gingrpcPackage = protogen.GoImportPath("github.com/dan-and-dna/gin-grpc")
+	corePackage    = protogen.GoImportPath("github.com/dan-and-dna/gin-grpc-network/core")
+)
+
+func main() {
+	showVersion := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
+	if *showVersion {
+		fmt.Printf("protoc-gen-gingrpcnetwork %s\n", version)
+		return
+	}
+
+	protogen.Options{}.Run(generate)
+}
+
+func generate(gen *protogen.Plugin) error {
+	// 只用到消息的类型，proto3 的 optional 字段不影响生成的代码
+	gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+	for _, f := range gen.Files {
+		if !f.Generate || len(f.Services) == 0 {
+			continue
+		}
+		generateFile(gen, f)
+	}
+	return nil
+}
+
+func generateFile(gen *protogen.Plugin, file *protogen.File) {
+	g := gen.NewGeneratedFile(file.GeneratedFilenamePrefix+"_gingrpcnetwork.pb.go", file.GoImportPath)
+	g.P("// Code generated by protoc-gen-gingrpcnetwork. DO NOT EDIT.")
+	g.P("// versions:")
+	g.P("// - protoc-gen-gingrpcnetwork v", version)
+	g.P("// source: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+
+	for _, service := range file.Services {
+		generateService(g, file, service)
+	}
+}
+
+func generateService(g *protogen.GeneratedFile, file *protogen.File, service *protogen.Service) {
+	handlerName := service.GoName + "Handler"
+	pkg := string(file.Desc.Package())
+	serviceName := string(service.Desc.Name())
+	serviceDesc := service.GoName + "_ServiceDesc"
+
+	// 处理接口
+	g.P("// ", handlerName, " 处理 ", service.Desc.FullName(), " 的请求")
+	g.P("type ", handlerName, " interface {")
+	for _, method := range service.Methods {
+		g.P(method.GoName, methodSignature(g, service, method))
+	}
+	g.P("}")
+	g.P()
+
+	// 注册函数
+	g.P("// Register", service.GoName, " 把 ", handlerName, " 的每个方法注册到网络层")
+	g.P("func Register", service.GoName, "(n ", corePackage.Ident("ProtoRegistrar"), ", impl ", handlerName, ") error {")
+	for _, method := range service.Methods {
+		methodName := string(method.Desc.Name())
+		input := g.QualifiedGoIdent(method.Input.GoIdent)
+
+		if !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer() {
+			g.P("if err := n.HandleProto(", quote(pkg), ", ", quote(serviceName), ", ", quote(methodName), ", &", serviceDesc, ", ", gingrpcPackage.Ident("Handler"), "{")
+			g.P("Proto: &", input, "{},")
+			g.P("HandleProto: func(ctx ", contextPackage.Ident("Context"), ", req interface{}) (interface{}, error) {")
+			g.P("return impl.", method.GoName, "(ctx, req.(*", input, "))")
+			g.P("},")
+			g.P("}); err != nil {")
+			g.P("return err")
+			g.P("}")
+			continue
+		}
+
+		g.P("if err := n.ListenProto(", quote(pkg), ", ", quote(serviceName), ", ", quote(methodName), ", &", serviceDesc, ", func(ss ", grpcPackage.Ident("ServerStream"), ") error {")
+		if method.Desc.IsStreamingClient() {
+			g.P("return impl.", method.GoName, "(&", streamTypeName(service, method), "{ss})")
+		} else {
+			g.P("req := new(", input, ")")
+			g.P("if err := ss.RecvMsg(req); err != nil {")
+			g.P("return err")
+			g.P("}")
+			g.P("return impl.", method.GoName, "(req, &", streamTypeName(service, method), "{ss})")
+		}
+		g.P("}); err != nil {")
+		g.P("return err")
+		g.P("}")
+	}
+	g.P("return nil")
+	g.P("}")
+	g.P()
+
+	for _, method := range service.Methods {
+		if method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() {
+			generateStream(g, service, method)
+		}
+	}
+}
+
+func methodSignature(g *protogen.GeneratedFile, service *protogen.Service, method *protogen.Method) string {
+	input := g.QualifiedGoIdent(method.Input.GoIdent)
+	output := g.QualifiedGoIdent(method.Output.GoIdent)
+	streamServer := service.GoName + "_" + method.GoName + "Server"
+
+	switch {
+	case method.Desc.IsStreamingClient():
+		return "(" + streamServer + ") error"
+	case method.Desc.IsStreamingServer():
+		return "(*" + input + ", " + streamServer + ") error"
+	}
+
+	return "(" + g.QualifiedGoIdent(contextPackage.Ident("Context")) + ", *" + input + ") (*" + output + ", error)"
+}
+
+// 实现 protoc-gen-go-grpc 生成的 Xxx_MethodServer 接口
+func generateStream(g *protogen.GeneratedFile, service *protogen.Service, method *protogen.Method) {
+	typeName := streamTypeName(service, method)
+	input := g.QualifiedGoIdent(method.Input.GoIdent)
+	output := g.QualifiedGoIdent(method.Output.GoIdent)
+
+	g.P("type ", typeName, " struct {")
+	g.P(grpcPackage.Ident("ServerStream"))
+	g.P("}")
+	g.P()
+
+	if method.Desc.IsStreamingServer() {
+		g.P("func (x *", typeName, ") Send(m *", output, ") error {")
+		g.P("return x.ServerStream.SendMsg(m)")
+		g.P("}")
+		g.P()
+	} else {
+		g.P("func (x *", typeName, ") SendAndClose(m *", output, ") error {")
+		g.P("return x.ServerStream.SendMsg(m)")
+		g.P("}")
+		g.P()
+	}
+
+	if method.Desc.IsStreamingClient() {
+		g.P("func (x *", typeName, ") Recv() (*", input, ", error) {")
+		g.P("m := new(", input, ")")
+		g.P("if err := x.ServerStream.RecvMsg(m); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return m, nil")
+		g.P("}")
+		g.P()
+	}
+}
+
+func streamTypeName(service *protogen.Service, method *protogen.Method) string {
+	return unexport(service.GoName) + method.GoName + "HandlerStream"
+}
+
+func unexport(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+func quote(s string) string {
+	return fmt.Sprintf("%q", s)
+}
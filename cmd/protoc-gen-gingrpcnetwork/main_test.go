@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/pluginpb"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestGolden(t *testing.T) {
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{ImportPaths: []string{"testdata"}}),
+	}
+	compiled, err := compiler.Compile(context.Background(), "greeter.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &pluginpb.CodeGeneratorRequest{FileToGenerate: []string{"greeter.proto"}}
+	for _, file := range compiled {
+		req.ProtoFile = append(req.ProtoFile, protodesc.ToFileDescriptorProto(file))
+	}
+
+	gen, err := protogen.Options{}.New(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := generate(gen); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := gen.Response()
+	if resp.Error != nil {
+		t.Fatal(resp.GetError())
+	}
+	if resp.GetSupportedFeatures()&uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL) == 0 {
+		t.Fatal("proto3 optional is not supported")
+	}
+	if len(resp.File) != 1 {
+		t.Fatalf("generated %d files", len(resp.File))
+	}
+
+	golden := filepath.Join("testdata", "greeter_gingrpcnetwork.pb.go.golden")
+	if *update {
+		if err := os.WriteFile(golden, []byte(resp.File[0].GetContent()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.File[0].GetContent(); got != string(want) {
+		t.Fatalf("%s differs from the golden file, run go test -update\n%s", resp.File[0].GetName(), got)
+	}
+}
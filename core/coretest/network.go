@@ -0,0 +1,151 @@
+// 假的网络层，业务测试时代替 core.Network，不监听端口，调用直接交给注册的处理者
+package coretest
+
+import (
+	"context"
+	"fmt"
+	gingrpc "github.com/dan-and-dna/gin-grpc"
+	"github.com/dan-and-dna/gin-grpc-network/core"
+	"github.com/dan-and-dna/gin-grpc-network/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+)
+
+var _ core.Network = (*Network)(nil)
+
+type Network struct {
+	handlers  map[string]gingrpc.Handler               // key为小写的grpc完整方法名
+	listeners map[string]func(grpc.ServerStream) error // 流方法只记录，不调用
+	notified  map[string][]interface{}                 // 通知给监听者的请求
+	cfg       core.NetworkCore
+	restarts  int
+	mu        sync.Mutex
+}
+
+func NewNetwork() *Network {
+	return &Network{
+		handlers:  make(map[string]gingrpc.Handler),
+		listeners: make(map[string]func(grpc.ServerStream) error),
+		notified:  make(map[string][]interface{}),
+	}
+}
+
+func (network *Network) HandleProto(pkg, service, method string, desc *grpc.ServiceDesc, handler gingrpc.Handler, middlewares ...grpc.UnaryServerInterceptor) error {
+	key := strings.ToLower(utils.MakeFullMethod(pkg, service, method))
+
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	if _, ok := network.handlers[key]; ok {
+		return fmt.Errorf("duplicate handler %s", key)
+	}
+	network.handlers[key] = handler
+	return nil
+}
+
+func (network *Network) ListenProto(pkg, service, method string, desc *grpc.ServiceDesc, listener func(grpc.ServerStream) error, middlewares ...grpc.StreamServerInterceptor) error {
+	key := strings.ToLower(utils.MakeFullMethod(pkg, service, method))
+
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	if _, ok := network.listeners[key]; ok {
+		return fmt.Errorf("duplicate listener %s", key)
+	}
+	network.listeners[key] = listener
+	return nil
+}
+
+func (network *Network) StopListenProto(pkg, service, method string) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	delete(network.listeners, strings.ToLower(utils.MakeFullMethod(pkg, service, method)))
+}
+
+func (network *Network) StopHandleProto(pkg, service, method string) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	delete(network.handlers, strings.ToLower(utils.MakeFullMethod(pkg, service, method)))
+}
+
+func (network *Network) GetConfig() *core.NetworkCore {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	cfg := new(core.NetworkCore)
+	cfg.CopyFrom(&network.cfg)
+	return cfg
+}
+
+func (network *Network) UpdateCfg(cfg *core.NetworkCore) error {
+	if cfg == nil {
+		return fmt.Errorf("nil network config")
+	}
+
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	network.cfg.CopyFrom(cfg)
+	network.restarts++
+	return nil
+}
+
+func (network *Network) NotifyListeners(ctx context.Context, req interface{}, key string) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	key = strings.ToLower(key)
+	network.notified[key] = append(network.notified[key], req)
+}
+
+func (network *Network) NotifyHandler(ctx context.Context, req interface{}, key string) (interface{}, error) {
+	network.mu.Lock()
+	handler, ok := network.handlers[strings.ToLower(key)]
+	network.mu.Unlock()
+
+	if !ok || handler.HandleProto == nil {
+		return nil, status.Errorf(codes.NotFound, "no handler for %s", key)
+	}
+	return handler.HandleProto(ctx, req)
+}
+
+func (network *Network) Invoke(ctx context.Context, pkg, service, method string, req interface{}) (interface{}, error) {
+	return network.NotifyHandler(ctx, req, utils.MakeFullMethod(pkg, service, method))
+}
+
+func (network *Network) ReStart() {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	network.restarts++
+}
+
+// 是否注册了处理者
+func (network *Network) Handled(pkg, service, method string) bool {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	_, ok := network.handlers[strings.ToLower(utils.MakeFullMethod(pkg, service, method))]
+	return ok
+}
+
+// 通知给监听者的请求，key为grpc的完整方法名
+func (network *Network) Notified(key string) []interface{} {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	return append([]interface{}(nil), network.notified[strings.ToLower(key)]...)
+}
+
+// UpdateCfg 和 ReStart 的次数
+func (network *Network) Restarts() int {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	return network.restarts
+}
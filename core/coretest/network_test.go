@@ -0,0 +1,92 @@
+package coretest
+
+import (
+	"context"
+	gingrpc "github.com/dan-and-dna/gin-grpc"
+	"github.com/dan-and-dna/gin-grpc-network/core"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+// 业务代码只依赖 core.Network
+type healthService struct {
+	n core.Network
+}
+
+func (service *healthService) register() error {
+	return service.n.HandleProto("grpc.health.v1", "Health", "Check", nil, gingrpc.Handler{
+		Proto: &grpc_health_v1.HealthCheckRequest{},
+		HandleProto: func(ctx context.Context, req interface{}) (interface{}, error) {
+			if req.(*grpc_health_v1.HealthCheckRequest).Service == "" {
+				return nil, status.Error(codes.InvalidArgument, "empty service")
+			}
+			service.n.NotifyListeners(ctx, req, "/grpc.health.v1.Health/Check")
+			return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+		},
+	})
+}
+
+func TestFakeNetwork(t *testing.T) {
+	fake := NewNetwork()
+	service := &healthService{n: fake}
+	if err := service.register(); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.register(); err == nil {
+		t.Fatal("duplicate register should fail")
+	}
+
+	resp, err := fake.Invoke(context.Background(), "grpc.health.v1", "Health", "Check", &grpc_health_v1.HealthCheckRequest{Service: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.(*grpc_health_v1.HealthCheckResponse).Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("unexpected response %v", resp)
+	}
+	if len(fake.Notified("/grpc.health.v1.Health/Check")) != 1 {
+		t.Fatal("listener was not notified")
+	}
+
+	_, err = fake.Invoke(context.Background(), "grpc.health.v1", "Health", "Check", &grpc_health_v1.HealthCheckRequest{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("err = %v", err)
+	}
+
+	fake.StopHandleProto("grpc.health.v1", "Health", "Check")
+	_, err = fake.Invoke(context.Background(), "grpc.health.v1", "Health", "Check", &grpc_health_v1.HealthCheckRequest{Service: "a"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestFakeNetworkListeners(t *testing.T) {
+	fake := NewNetwork()
+	listener := func(grpc.ServerStream) error { return nil }
+	if err := fake.ListenProto("grpc.health.v1", "Health", "Watch", nil, listener); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.ListenProto("grpc.health.v1", "health", "watch", nil, listener); err == nil {
+		t.Fatal("duplicate listener should fail")
+	}
+
+	fake.StopListenProto("grpc.health.v1", "Health", "Watch")
+	if err := fake.ListenProto("grpc.health.v1", "Health", "Watch", nil, listener); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFakeNetworkConfig(t *testing.T) {
+	fake := NewNetwork()
+	cfg := fake.GetConfig()
+	cfg.ListenPort = 8080
+	cfg.HttpMiddlewares = nil
+	if err := fake.UpdateCfg(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if fake.GetConfig().ListenPort != 8080 || fake.Restarts() != 1 {
+		t.Fatalf("config was not updated: port=%d restarts=%d", fake.GetConfig().ListenPort, fake.Restarts())
+	}
+}
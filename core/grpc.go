@@ -8,9 +8,18 @@ import (
 type GrpcCore struct {
 	singleinstmodule.SingleInstModuleCore
 
-	Enable            bool   // 是否启动模块
-	ListenIp          string // http 监听ip
-	ListenPort        int    // http 监听端口
+	Enable            bool              // 是否启动模块
+	ListenIp          string            // http 监听ip
+	ListenPort        int               // http 监听端口
+	HandleTimeOut     int               // 默认处理超时(毫秒)
+	ServiceTimeOuts   map[string]int    // 服务的处理超时(毫秒)，key为 pkg.service
+	MethodTimeOuts    map[string]int    // 方法的处理超时(毫秒)，key为 /pkg.service/method
+	StrictRegister    bool              // 严格模式，有注册失败时启动直接 panic
+	CaseSensitive     bool              // 路由区分大小写，按grpc的完整方法名匹配
+	ProxyTarget       string            // 没有处理者的方法原样转发给这个grpc服务，比如迁移中的旧服务
+	ProxyDialOptions  []grpc.DialOption // 连接上游的设置，比如 TLS，为空时不加密
+	Compressor        string            // 调用上游(代理、转发、镜像)时的压缩算法，gzip 或 zstd，为空不压缩
+	ServerCompressor  string            // 服务端回复的压缩算法，gzip 或 zstd，为空时按客户端请求使用的算法；客户端需要支持这个算法
 	Middlewares       []grpc.UnaryServerInterceptor
 	MiddlewaresStream []grpc.StreamServerInterceptor
 }
@@ -1,9 +1,12 @@
 package core
 
 import (
+	"encoding/json"
 	gingrpc "github.com/dan-and-dna/gin-grpc"
 	"github.com/dan-and-dna/singleinstmodule"
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
 )
 
 type HttpCore struct {
@@ -12,10 +15,55 @@ type HttpCore struct {
 	Enable            bool                      // 是否启动模块
 	ListenIp          string                    // http 监听ip
 	ListenPort        int                       // http 监听端口
+	HandleTimeOut     int                       // 默认处理超时(毫秒)
+	ServiceTimeOuts   map[string]int            // 服务的处理超时(毫秒)，key为 pkg.service
+	MethodTimeOuts    map[string]int            // 方法的处理超时(毫秒)，key为 /pkg.service/method
+	StrictRegister    bool                      // 严格模式，有注册失败时启动直接 panic
+	CaseSensitive     bool                      // 路由区分大小写，按grpc的完整方法名匹配
 	ReadTimeOut       int                       // 读超时
 	WriteTimeOut      int                       // 写超时
 	PathToServiceName func(*gin.Context) string // path 转成 grpc 服务
+	PathResolver      string                    // 内置的 path 解析方式，PathToServiceName 为空时生效
 	Path              string                    // path
+	AdminPath         string                    // 查看路由表的路径，为空不开启
+	AdminMiddlewares  []gin.HandlerFunc         // 查看路由表前执行的中间件，比如鉴权，路由表会暴露所有的方法
+	RemoteServices    map[string]string         // 转发给远程grpc服务的服务，key为 pkg.service，value为grpc地址
+	RemoteDialOptions []grpc.DialOption         // 连接远程grpc服务的设置，为空时不加密；重启时设置变了会重新连接
+	JsonOptions       *JsonOptions              // json的编码设置，为空时沿用 encoding/json
+	Compression       *CompressionOptions       // 回复的压缩设置，为空不压缩
+	Envelope          Envelope                  // json回复的信封，为空不包装
 	Middlewares       []gin.HandlerFunc         // http中间件
 	CtxOptions        []gingrpc.GrpcCtxOption
 }
+
+// http的json编码设置，设置后按 protojson 编码
+// int64 和 uint64 总是输出为字符串，js不会丢精度，protojson 没有输出为数字的选项；解码时字符串和数字都接受
+type JsonOptions struct {
+	EmitUnpopulated bool // 输出零值的字段
+	UseProtoNames   bool // 使用proto里的字段名，否则用lowerCamel
+	UseEnumNumbers  bool // 枚举输出数字，否则输出名字
+	DiscardUnknown  bool // 解码时忽略不认识的字段
+}
+
+// json回复的信封，所有处理者的回复和错误统一包装，比如 {"code":0,"msg":"ok","data":{...}}
+type Envelope interface {
+	// 拆开json请求的信封，返回里面的请求，没有信封时原样返回
+	Unwrap(c *gin.Context, body []byte) ([]byte, error)
+	// 包装成功的回复，data 是编码好的回复
+	Wrap(c *gin.Context, data json.RawMessage) interface{}
+	// 包装错误，code 是grpc错误码对应的http状态码，返回最终的http状态码和回复
+	WrapError(c *gin.Context, code int, s *status.Status) (int, interface{})
+}
+
+// http回复的压缩设置，按 Accept-Encoding 选择算法
+type CompressionOptions struct {
+	Encodings []string // 可用的算法 gzip、zstd、br，客户端都支持时选前面的，为空时全部可用
+	MinSize   int      // 回复达到这个大小(字节)才压缩，0为1024
+}
+
+// 内置的 path 解析方式
+const (
+	PathResolverPath     = "path"      // /{pkg}/{service}/{method}
+	PathResolverGrpcPath = "grpc_path" // /{pkg}.{service}/{method}
+	PathResolverHeader   = "header"    // X-Rpc-Method: /{pkg}.{service}/{method}
+)
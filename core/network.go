@@ -6,6 +6,7 @@ import (
 	"github.com/dan-and-dna/singleinstmodule"
 	"github.com/gin-gonic/gin"
 	"google.golang.org/grpc"
+	"reflect"
 )
 
 type NetworkCore struct {
@@ -18,40 +19,69 @@ type NetworkCore struct {
 	ListenIp   string // 监听ip
 	ListenPort int    // 监听port
 
+	StrictRegister bool // 严格模式，有注册失败时启动直接 panic
+	CaseSensitive  bool // 路由区分大小写，按grpc的完整方法名匹配
+
+	// 处理超时(毫秒)，方法优先，其次是服务，最后是默认，0表示不限制
+	// 超时通过 ctx 通知处理者，不会强行中断，处理者需要检查 ctx.Done()
+	HandleTimeOut   int            // 默认处理超时
+	ServiceTimeOuts map[string]int // 服务的处理超时，key为 pkg.service
+	MethodTimeOuts  map[string]int // 方法的处理超时，key为 /pkg.service/method
+
+	// 转发给远程grpc服务的服务，key为 pkg.service，value为grpc地址
+	RemoteServices    map[string]string
+	RemoteDialOptions []grpc.DialOption // 连接远程grpc服务的设置，为空时不加密
+
 	// http
 	HttpReadTimeOut       int                       // http服务读超时
 	HttpWriteTimeOut      int                       // http服务写超时
 	HttpMiddlewares       []gin.HandlerFunc         // http中间件
 	HttpPathToServiceName func(*gin.Context) string // http路径转grpc的服务名
+	HttpPathResolver      string                    // 内置的http路径解析方式
 	HttpPath              string
+	HttpAdminPath         string              // 查看路由表的路径
+	HttpAdminMiddlewares  []gin.HandlerFunc   // 查看路由表前执行的中间件，比如鉴权
+	HttpJsonOptions       *JsonOptions        // json的编码设置，为空时沿用 encoding/json
+	HttpCompression       *CompressionOptions // 回复的压缩设置，为空不压缩
+	HttpEnvelope          Envelope            // json回复的信封，为空不包装
 	HttpCtxOptions        []gingrpc.GrpcCtxOption
 
 	// grpc
 	GrpcMiddlewares       []grpc.UnaryServerInterceptor  // grpc中间件
 	GrpcMiddlewaresStream []grpc.StreamServerInterceptor // grpc中间件
+	GrpcProxyTarget       string                         // 没有处理者的方法原样转发给这个grpc服务，为空时返回 NotFound
+	GrpcProxyDialOptions  []grpc.DialOption              // 连接上游的设置，比如 TLS，为空时不加密
+	GrpcCompressor        string                         // 调用上游grpc时的压缩算法，gzip 或 zstd，为空不压缩
+	GrpcServerCompressor  string                         // grpc服务端回复的压缩算法，gzip 或 zstd，为空时按客户端请求使用的算法
 }
 
+// 网络层，业务通过它注册和调用协议，测试时可以换成假的实现
 type Network interface {
-	// 监听消息
-	ListenProto(pkg, service, method string, listener func(context.Context, interface{}))
-	// 处理消息
-	HandleProto(pkg, service, method string, handler func(context.Context, interface{}) (interface{}, error))
+	ProtoRegistrar
 	// 停止监听消息
 	StopListenProto(pkg, service, method string)
 	// 停止处理消息
 	StopHandleProto(pkg, service, method string)
-	// 获得当前配置
-	GetConfig() NetworkCore
-	// 更新配置
-	UpdateCfg(cfg NetworkCore) error
+	// 获得当前配置的副本
+	GetConfig() *NetworkCore
+	// 更新配置，校验通过后重新启动
+	UpdateCfg(cfg *NetworkCore) error
 	// 通知协议给监听者
 	NotifyListeners(ctx context.Context, req interface{}, key string)
 	// 通知协议给处理者
 	NotifyHandler(ctx context.Context, req interface{}, key string) (interface{}, error)
+	// 在进程内调用处理者，经过和grpc请求一样的中间件
+	Invoke(ctx context.Context, pkg, service, method string, req interface{}) (interface{}, error)
 	// 重新启动
 	ReStart()
 }
 
+// 协议注册者，生成的注册代码通过它注册服务
+type ProtoRegistrar interface {
+	HandleProto(pkg, service, method string, desc *grpc.ServiceDesc, handler gingrpc.Handler, middlewares ...grpc.UnaryServerInterceptor) error
+	ListenProto(pkg, service, method string, desc *grpc.ServiceDesc, listener func(grpc.ServerStream) error, middlewares ...grpc.StreamServerInterceptor) error
+}
+
 type ProtoListener interface {
 	Listen(context.Context, interface{})
 }
@@ -59,3 +89,65 @@ type ProtoListener interface {
 type ProtoHandler interface {
 	Handle(context.Context, interface{}) (interface{}, error)
 }
+
+// 复制配置，不复制锁，切片和map都是新的，函数、接口和中间件按引用复制
+func (cfg *NetworkCore) CopyFrom(other *NetworkCore) {
+	dst := reflect.ValueOf(cfg).Elem()
+	src := reflect.ValueOf(other).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		if dst.Type().Field(i).Anonymous {
+			continue
+		}
+
+		dst.Field(i).Set(copyValue(src.Field(i)))
+	}
+}
+
+// 接口的实现可能有不导出的字段，不能深复制
+func copyValue(value reflect.Value) reflect.Value {
+	switch value.Kind() {
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+
+		m := reflect.MakeMapWithSize(value.Type(), value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			m.SetMapIndex(iter.Key(), copyValue(iter.Value()))
+		}
+		return m
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+
+		s := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for i := 0; i < value.Len(); i++ {
+			s.Index(i).Set(copyValue(value.Index(i)))
+		}
+		return s
+	case reflect.Pointer:
+		// 只复制全是导出字段的设置，比如 JsonOptions
+		if value.IsNil() || value.Elem().Kind() != reflect.Struct || !plainStruct(value.Elem().Type()) {
+			return value
+		}
+
+		p := reflect.New(value.Elem().Type())
+		for i := 0; i < value.Elem().NumField(); i++ {
+			p.Elem().Field(i).Set(copyValue(value.Elem().Field(i)))
+		}
+		return p
+	}
+
+	return value
+}
+
+func plainStruct(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if !t.Field(i).IsExported() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/status"
+	"sync"
+	"testing"
+)
+
+// 有不导出字段的信封
+type prefixEnvelope struct {
+	mu     *sync.Mutex
+	prefix string
+}
+
+func (envelope *prefixEnvelope) Unwrap(c *gin.Context, body []byte) ([]byte, error) {
+	return body, nil
+}
+
+func (envelope *prefixEnvelope) Wrap(c *gin.Context, data json.RawMessage) interface{} {
+	envelope.mu.Lock()
+	defer envelope.mu.Unlock()
+	return envelope.prefix
+}
+
+func (envelope *prefixEnvelope) WrapError(c *gin.Context, code int, s *status.Status) (int, interface{}) {
+	return code, envelope.prefix
+}
+
+func TestCopyFrom(t *testing.T) {
+	envelope := &prefixEnvelope{mu: new(sync.Mutex), prefix: "p"}
+	src := &NetworkCore{
+		ServiceTimeOuts: map[string]int{"pkg.Service": 100},
+		HttpMiddlewares: []gin.HandlerFunc{func(*gin.Context) {}},
+		HttpEnvelope:    envelope,
+		HttpJsonOptions: &JsonOptions{UseProtoNames: true},
+		HttpCompression: &CompressionOptions{Encodings: []string{"gzip"}},
+	}
+
+	dst := new(NetworkCore)
+	dst.CopyFrom(src)
+
+	// 接口按引用复制，不丢不导出的字段
+	if dst.HttpEnvelope != envelope || dst.HttpEnvelope.Wrap(nil, nil) != "p" {
+		t.Fatalf("envelope was not kept: %#v", dst.HttpEnvelope)
+	}
+
+	// map、切片和设置是新的
+	dst.ServiceTimeOuts["pkg.Service"] = 1
+	dst.HttpJsonOptions.UseProtoNames = false
+	dst.HttpCompression.Encodings[0] = "br"
+	if src.ServiceTimeOuts["pkg.Service"] != 100 || !src.HttpJsonOptions.UseProtoNames || src.HttpCompression.Encodings[0] != "gzip" {
+		t.Fatal("copy shares memory with the source")
+	}
+
+	if len(dst.HttpMiddlewares) != 1 || dst.HttpMiddlewares[0] == nil {
+		t.Fatal("middlewares were not copied")
+	}
+}
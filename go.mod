@@ -3,15 +3,19 @@ module github.com/dan-and-dna/gin-grpc-network
 go 1.19
 
 require (
+	github.com/andybalholm/brotli v1.0.4
+	github.com/bufbuild/protocompile v0.6.0
 	github.com/dan-and-dna/gin-grpc v0.0.0-20221109164324-7d4ba9c7345b
 	github.com/dan-and-dna/grpc-route v0.0.0-20221117025141-4fa6cc23ec72
 	github.com/dan-and-dna/singleinstmodule v0.0.0-20221111094655-2dd9a2972075
 	github.com/gin-gonic/gin v1.8.1
 	github.com/grpc-ecosystem/go-grpc-middleware v1.3.0
-	github.com/mohae/deepcopy v0.0.0-20170929034955-c48cc78d4826
+	github.com/klauspost/compress v1.15.12
 	github.com/spf13/viper v1.14.0
 	go.uber.org/zap v1.23.0
+	google.golang.org/genproto v0.0.0-20221024183307-1bc688fe9f3e
 	google.golang.org/grpc v1.50.1
+	google.golang.org/protobuf v1.31.0
 )
 
 require (
@@ -42,10 +46,9 @@ require (
 	go.uber.org/multierr v1.8.0 // indirect
 	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e // indirect
 	golang.org/x/net v0.0.0-20221014081412-f15817d10f9b // indirect
+	golang.org/x/sync v0.3.0 // indirect
 	golang.org/x/sys v0.0.0-20220908164124-27713097b956 // indirect
 	golang.org/x/text v0.4.0 // indirect
-	google.golang.org/genproto v0.0.0-20221024183307-1bc688fe9f3e // indirect
-	google.golang.org/protobuf v1.28.1 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -105,6 +105,15 @@ func (grpc *Grpc) Recreate() {
 		cfg.ListenHttp = false
 		cfg.ListenIp = grpcCore.ListenIp
 		cfg.ListenPort = grpcCore.ListenPort
+		cfg.HandleTimeOut = grpcCore.HandleTimeOut
+		cfg.ServiceTimeOuts = grpcCore.ServiceTimeOuts
+		cfg.MethodTimeOuts = grpcCore.MethodTimeOuts
+		cfg.StrictRegister = grpcCore.StrictRegister
+		cfg.CaseSensitive = grpcCore.CaseSensitive
+		cfg.GrpcProxyTarget = grpcCore.ProxyTarget
+		cfg.GrpcProxyDialOptions = grpcCore.ProxyDialOptions
+		cfg.GrpcCompressor = grpcCore.Compressor
+		cfg.GrpcServerCompressor = grpcCore.ServerCompressor
 		cfg.GrpcMiddlewares = append(cfg.GrpcMiddlewares, grpcCore.Middlewares...)
 		cfg.GrpcMiddlewaresStream = append(cfg.GrpcMiddlewaresStream, grpcCore.MiddlewaresStream...)
 	}
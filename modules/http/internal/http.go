@@ -102,10 +102,23 @@ func (http *Http) Recreate() {
 		cfg.ListenGrpc = false
 		cfg.ListenIp = httpCore.ListenIp
 		cfg.ListenPort = httpCore.ListenPort
+		cfg.HandleTimeOut = httpCore.HandleTimeOut
+		cfg.ServiceTimeOuts = httpCore.ServiceTimeOuts
+		cfg.MethodTimeOuts = httpCore.MethodTimeOuts
+		cfg.StrictRegister = httpCore.StrictRegister
+		cfg.CaseSensitive = httpCore.CaseSensitive
 		cfg.HttpReadTimeOut = httpCore.ReadTimeOut
 		cfg.HttpWriteTimeOut = httpCore.WriteTimeOut
 		cfg.HttpPathToServiceName = httpCore.PathToServiceName
+		cfg.HttpPathResolver = httpCore.PathResolver
 		cfg.HttpPath = httpCore.Path
+		cfg.HttpAdminPath = httpCore.AdminPath
+		cfg.HttpAdminMiddlewares = httpCore.AdminMiddlewares
+		cfg.RemoteServices = httpCore.RemoteServices
+		cfg.RemoteDialOptions = httpCore.RemoteDialOptions
+		cfg.HttpJsonOptions = httpCore.JsonOptions
+		cfg.HttpCompression = httpCore.Compression
+		cfg.HttpEnvelope = httpCore.Envelope
 		cfg.HttpMiddlewares = append(cfg.HttpMiddlewares, httpCore.Middlewares...)
 		cfg.HttpCtxOptions = append(cfg.HttpCtxOptions, httpCore.CtxOptions...)
 	}
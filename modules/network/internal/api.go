@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"github.com/dan-and-dna/gin-grpc-network/core"
+	"github.com/dan-and-dna/gin-grpc-network/utils"
+	"google.golang.org/grpc/metadata"
+)
+
+var _ core.Network = (*Network)(nil)
+
+// 获得当前配置的副本
+func (network *Network) GetConfig() *core.NetworkCore {
+	network.core.RLock()
+	defer network.core.RUnlock()
+
+	cfg := new(core.NetworkCore)
+	cfg.CopyFrom(network.core)
+	return cfg
+}
+
+// 更新配置，校验通过后重新启动
+func (network *Network) UpdateCfg(cfg *core.NetworkCore) error {
+	if cfg == nil {
+		return fmt.Errorf("nil network config")
+	}
+
+	if err := validateConfig(cfg); err != nil {
+		return err
+	}
+
+	// 严格模式下不允许因为切换大小写模式丢掉注册
+	if cfg.StrictRegister && !cfg.CaseSensitive {
+		network.mu.Lock()
+		drops := network.caseInsensitiveDrops()
+		network.mu.Unlock()
+		if len(drops) > 0 {
+			return fmt.Errorf("%w: case insensitive routing would drop %v", ErrDuplicateRegister, drops)
+		}
+	}
+
+	network.core.Lock()
+	network.core.CopyFrom(cfg)
+	network.core.Unlock()
+
+	network.CoreChanged()
+	return nil
+}
+
+// 重新启动
+func (network *Network) ReStart() {
+	network.CoreChanged()
+}
+
+// 添加协议的监听者，收到的请求会异步通知给它
+func (network *Network) AddProtoListener(pkg, service, method string, listener core.ProtoListener) {
+	if listener == nil {
+		return
+	}
+
+	network.Subscribe(pkg, service, method, SubscribeOption{}, func(event Event) {
+		listener.Listen(metadata.NewIncomingContext(context.Background(), event.Metadata), event.Req)
+	})
+}
+
+// 订阅协议，每次收到请求都会异步收到一份副本，返回的订阅用来取消
+// 只发布普通方法的请求，流方法(AddProtoListener 注册的监听者)的消息不发布
+func (network *Network) Subscribe(pkg, service, method string, option SubscribeOption, observer func(Event)) *Subscription {
+	return network.bus.Subscribe(utils.MakeFullMethod(pkg, service, method), option, observer)
+}
+
+// 移除协议的所有监听者
+func (network *Network) RemoveProtoListeners(pkg, service, method string) {
+	network.bus.UnsubscribeAll(utils.MakeFullMethod(pkg, service, method))
+}
+
+// 通知协议给监听者，key为grpc的完整方法名
+func (network *Network) NotifyListeners(ctx context.Context, req interface{}, key string) {
+	network.bus.Publish(ctx, key, req, nil, nil, false)
+}
+
+// 通知协议给处理者，key为grpc的完整方法名，和 Invoke 一样经过中间件
+func (network *Network) NotifyHandler(ctx context.Context, req interface{}, key string) (interface{}, error) {
+	return network.invoke(ctx, key, req)
+}
+
+func validateConfig(cfg *core.NetworkCore) error {
+	if cfg.ListenHttp && cfg.ListenGrpc {
+		return fmt.Errorf("can not listen http and grpc at the same time")
+	}
+
+	if cfg.ListenHttp && cfg.HttpPathToServiceName == nil {
+		if _, err := GetPathResolver(cfg.HttpPathResolver); err != nil {
+			return err
+		}
+	}
+
+	if err := checkCompression(cfg.HttpCompression); err != nil {
+		return err
+	}
+
+	if err := checkGrpcCompressor(cfg.GrpcCompressor); err != nil {
+		return err
+	}
+
+	if err := checkGrpcCompressor(cfg.GrpcServerCompressor); err != nil {
+		return err
+	}
+
+	if (cfg.ListenHttp || cfg.ListenGrpc) && (cfg.ListenPort < 0 || cfg.ListenPort > 65535) {
+		return fmt.Errorf("bad listen port: %d", cfg.ListenPort)
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package internal
+
+import (
+	"context"
+	"github.com/dan-and-dna/gin-grpc-network/utils"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 默认的订阅队列长度
+const defaultQueueSize = 1024
+
+// 订阅队列满时的策略
+type OverflowPolicy int
+
+const (
+	DropNewest OverflowPolicy = iota // 丢弃新的事件
+	DropOldest                       // 丢弃最旧的事件
+	Block                            // 阻塞发布者直到有空位，请求的 ctx 结束时丢弃
+)
+
+// 收到的一次请求
+type Event struct {
+	Key      string      // grpc的完整方法名
+	Metadata metadata.MD // 请求的metadata，http请求为http头部
+	Req      interface{} // 请求的副本
+	Resp     interface{} // 回复的副本，订阅时打开 WithResponse 才有
+	Err      error       // 处理的错误，订阅时打开 WithResponse 才有
+	Time     time.Time   // 收到请求的时间
+}
+
+type SubscribeOption struct {
+	QueueSize    int            // 队列长度，0为默认长度
+	Overflow     OverflowPolicy // 队列满时的策略
+	WithResponse bool           // 是否需要回复
+}
+
+// 一个订阅者，有自己的队列和goroutine，慢的订阅者不影响别的订阅者
+type Subscription struct {
+	key      string
+	option   SubscribeOption
+	observer func(Event)
+	queue    chan Event
+	done     chan struct{}
+	dropped  atomic.Uint64
+	bus      *EventBus
+	once     sync.Once
+}
+
+// 因为队列满丢弃的事件数
+func (sub *Subscription) Dropped() uint64 {
+	return sub.dropped.Load()
+}
+
+// 取消订阅，队列中还没处理的事件会被丢弃
+func (sub *Subscription) Unsubscribe() {
+	sub.once.Do(func() {
+		sub.bus.remove(sub)
+		close(sub.done)
+	})
+}
+
+func (sub *Subscription) run() {
+	for {
+		select {
+		case <-sub.done:
+			return
+		case event := <-sub.queue:
+			sub.notify(event)
+		}
+	}
+}
+
+func (sub *Subscription) notify(event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[network] subscriber of %s panicked: %v\n%s", sub.key, r, debug.Stack())
+		}
+	}()
+
+	sub.observer(event)
+}
+
+func (sub *Subscription) push(ctx context.Context, event Event) {
+	switch sub.option.Overflow {
+	case Block:
+		select {
+		case sub.queue <- event:
+		case <-sub.done:
+		case <-ctx.Done():
+			sub.dropped.Add(1)
+		}
+		return
+	case DropOldest:
+		for {
+			select {
+			case sub.queue <- event:
+				return
+			default:
+			}
+
+			select {
+			case <-sub.queue:
+				sub.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case sub.queue <- event:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// 进程内的事件总线，key为小写的完整方法名，发布时不加锁，只发布普通方法的请求
+type EventBus struct {
+	subscribers atomic.Pointer[map[string][]*Subscription]
+	mu          sync.Mutex
+}
+
+func (bus *EventBus) Subscribe(fullMethod string, option SubscribeOption, observer func(Event)) *Subscription {
+	if option.QueueSize <= 0 {
+		option.QueueSize = defaultQueueSize
+	}
+
+	sub := &Subscription{
+		key:      utils.NormalizeKey(fullMethod, false),
+		option:   option,
+		observer: observer,
+		queue:    make(chan Event, option.QueueSize),
+		done:     make(chan struct{}),
+		bus:      bus,
+	}
+
+	bus.update(func(subscribers map[string][]*Subscription) {
+		subscribers[sub.key] = append(subscribers[sub.key][:len(subscribers[sub.key]):len(subscribers[sub.key])], sub)
+	})
+
+	go sub.run()
+	return sub
+}
+
+// 取消某个方法的所有订阅
+func (bus *EventBus) UnsubscribeAll(fullMethod string) {
+	subscribers := bus.subscribers.Load()
+	if subscribers == nil {
+		return
+	}
+
+	for _, sub := range (*subscribers)[utils.NormalizeKey(fullMethod, false)] {
+		sub.Unsubscribe()
+	}
+}
+
+// 是否有订阅者，没有订阅者时不需要复制请求
+func (bus *EventBus) Has(fullMethod string) bool {
+	subscribers := bus.subscribers.Load()
+	if subscribers == nil || len(*subscribers) == 0 {
+		return false
+	}
+
+	return len((*subscribers)[utils.NormalizeKey(fullMethod, false)]) > 0
+}
+
+// 发布事件，每个订阅者收到各自的请求副本
+func (bus *EventBus) Publish(ctx context.Context, fullMethod string, req, resp interface{}, err error, withResponse bool) {
+	subscribers := bus.subscribers.Load()
+	if subscribers == nil {
+		return
+	}
+
+	subs := (*subscribers)[utils.NormalizeKey(fullMethod, false)]
+	if len(subs) == 0 {
+		return
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	now := time.Now()
+	for _, sub := range subs {
+		event := Event{Key: fullMethod, Metadata: md.Copy(), Req: cloneMessage(req), Time: now}
+		if sub.option.WithResponse && withResponse {
+			event.Resp = cloneMessage(resp)
+			event.Err = err
+		}
+		sub.push(ctx, event)
+	}
+}
+
+func (bus *EventBus) remove(sub *Subscription) {
+	bus.update(func(subscribers map[string][]*Subscription) {
+		subs := subscribers[sub.key]
+		remain := make([]*Subscription, 0, len(subs))
+		for _, s := range subs {
+			if s != sub {
+				remain = append(remain, s)
+			}
+		}
+
+		if len(remain) == 0 {
+			delete(subscribers, sub.key)
+			return
+		}
+		subscribers[sub.key] = remain
+	})
+}
+
+// 写时复制
+func (bus *EventBus) update(modify func(map[string][]*Subscription)) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	subscribers := make(map[string][]*Subscription)
+	if old := bus.subscribers.Load(); old != nil {
+		for key, subs := range *old {
+			subscribers[key] = subs
+		}
+	}
+
+	modify(subscribers)
+	bus.subscribers.Store(&subscribers)
+}
+
+// 包装处理者，处理完后把请求和回复发布给订阅者
+func (network *Network) publishHandler(fullMethod string, handler func(context.Context, interface{}) (interface{}, error)) func(context.Context, interface{}) (interface{}, error) {
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		if !network.bus.Has(fullMethod) {
+			return handler(ctx, req)
+		}
+
+		// 处理者可能修改请求，先复制一份
+		event := cloneMessage(req)
+		resp, err := handler(ctx, req)
+		network.bus.Publish(ctx, fullMethod, event, resp, err, true)
+		return resp, err
+	}
+}
+
+// 协议做深拷贝，订阅者修改副本不影响处理者
+func cloneMessage(message interface{}) interface{} {
+	if m, ok := message.(proto.Message); ok && m != nil {
+		return proto.Clone(m)
+	}
+	return message
+}
@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBusBlockRespectsContext(t *testing.T) {
+	bus := new(EventBus)
+	unblock := make(chan struct{})
+	sub := bus.Subscribe("/pkg.Service/Method", SubscribeOption{QueueSize: 1, Overflow: Block}, func(Event) { <-unblock })
+	defer sub.Unsubscribe()
+	defer close(unblock)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// 订阅者卡住，队列满后发布者按 ctx 放弃
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			bus.Publish(ctx, "/pkg.Service/Method", nil, nil, nil, false)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publisher blocked after ctx was done")
+	}
+	if sub.Dropped() == 0 {
+		t.Fatal("no events dropped")
+	}
+}
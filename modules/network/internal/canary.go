@@ -0,0 +1,228 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	gingrpc "github.com/dan-and-dna/gin-grpc"
+	"github.com/dan-and-dna/gin-grpc-network/utils"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"hash/fnv"
+	"net"
+	"reflect"
+	"strings"
+)
+
+// 灰度规则，一条规则里设置的条件都满足才命中，规则按顺序匹配
+// 第一条命中并且版本已经注册的规则决定版本，版本没有注册时继续匹配下一条
+type CanaryRule struct {
+	Version string   `json:"version"`            // 命中时使用的版本
+	Header  string   `json:"header,omitempty"`   // 按metadata(http头部)匹配，比如 x-canary
+	Values  []string `json:"values,omitempty"`   // Header 的值，为空时只要带了这个头部就命中
+	HashKey string   `json:"hash_key,omitempty"` // 按这个metadata的值哈希分流，比如 x-user-id，同一个值总是命中同一个版本
+	Percent int      `json:"percent,omitempty"`  // 哈希落在 [0, Percent) 的请求命中，HashKey 不为空时生效
+	Peers   []string `json:"peers,omitempty"`    // 对端地址，ip或者CIDR
+}
+
+// 编译后的规则
+type canaryRule struct {
+	CanaryRule
+	peers []*net.IPNet
+}
+
+// 一个方法的所有版本和灰度规则，发布后只读
+type canary struct {
+	rules    []canaryRule
+	versions map[string]*gingrpc.Handler
+}
+
+func (rule *canaryRule) match(ctx context.Context, md metadata.MD) bool {
+	if rule.Header != "" {
+		values := md.Get(rule.Header)
+		if len(values) == 0 || (len(rule.Values) > 0 && !containsAny(rule.Values, values)) {
+			return false
+		}
+	}
+
+	if rule.HashKey != "" {
+		values := md.Get(rule.HashKey)
+		if len(values) == 0 || hashPercent(values[0]) >= rule.Percent {
+			return false
+		}
+	}
+
+	if len(rule.peers) > 0 && !matchPeer(ctx, rule.peers) {
+		return false
+	}
+
+	return true
+}
+
+// 选出这次请求的版本，没有命中时用默认的处理者
+func (c *canary) pick(ctx context.Context) (*gingrpc.Handler, bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for i := range c.rules {
+		if !c.rules[i].match(ctx, md) {
+			continue
+		}
+
+		if handler, ok := c.versions[c.rules[i].Version]; ok {
+			return handler, true
+		}
+	}
+
+	return nil, false
+}
+
+// 注册方法的另一个版本，请求的类型需要和默认的处理者一致
+func (network *Network) HandleProtoVersion(pkg, service, method, version string, handler gingrpc.Handler) error {
+	fullMethod := utils.MakeFullMethod(pkg, service, method)
+	if version == "" || handler.HandleProto == nil {
+		return fmt.Errorf("%w: bad version %q of %s", ErrBadRegister, version, fullMethod)
+	}
+
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	if reg, ok := network.findRegistration(pkg, service, method); ok && reg.handler != nil && reg.handler.Proto != nil && handler.Proto != nil {
+		if reflect.TypeOf(reg.handler.Proto) != reflect.TypeOf(handler.Proto) {
+			return &TypeMismatchError{Key: fullMethod, Want: fmt.Sprintf("%T", reg.handler.Proto), Got: fmt.Sprintf("%T", handler.Proto)}
+		}
+	}
+
+	network.updateCanary(network.methodFullName(pkg, service, method), func(c *canary) {
+		c.versions[version] = &handler
+	})
+	return nil
+}
+
+// 取消方法的一个版本
+func (network *Network) StopHandleProtoVersion(pkg, service, method, version string) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	network.updateCanary(network.methodFullName(pkg, service, method), func(c *canary) {
+		delete(c.versions, version)
+	})
+}
+
+// 设置方法的灰度规则，替换原来的规则
+func (network *Network) SetCanaryRules(pkg, service, method string, rules ...CanaryRule) error {
+	compiled := make([]canaryRule, 0, len(rules))
+	for _, rule := range rules {
+		peers, err := parsePeers(rule.Peers)
+		if err != nil {
+			return err
+		}
+
+		rule.Header = strings.ToLower(rule.Header)
+		rule.HashKey = strings.ToLower(rule.HashKey)
+		compiled = append(compiled, canaryRule{CanaryRule: rule, peers: peers})
+	}
+
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	network.updateCanary(network.methodFullName(pkg, service, method), func(c *canary) {
+		c.rules = compiled
+	})
+	return nil
+}
+
+// 复制后修改，调用者需要持有 network.mu
+func (network *Network) updateCanary(fullMethod string, modify func(*canary)) {
+	c := &canary{versions: make(map[string]*gingrpc.Handler)}
+	if old, ok := network.canaries.Get(fullMethod); ok {
+		c.rules = old.rules
+		for version, handler := range old.versions {
+			c.versions[version] = handler
+		}
+	}
+
+	modify(c)
+	if len(c.rules) == 0 && len(c.versions) == 0 {
+		network.canaries.Remove(fullMethod)
+		return
+	}
+	network.canaries.Set(fullMethod, c)
+}
+
+// 包装处理者，按灰度规则选择版本
+func (network *Network) canaryHandler(fullMethod string, handler func(context.Context, interface{}) (interface{}, error)) func(context.Context, interface{}) (interface{}, error) {
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		if c, ok := network.canaries.Get(fullMethod); ok {
+			if version, ok := c.pick(ctx); ok {
+				return version.HandleProto(ctx, req)
+			}
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func containsAny(want, values []string) bool {
+	for _, value := range values {
+		for _, w := range want {
+			if value == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 0-99
+func hashPercent(value string) int {
+	h := fnv.New32a()
+	h.Write([]byte(value))
+	return int(h.Sum32() % 100)
+}
+
+func parsePeers(peers []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(peers))
+	for _, p := range peers {
+		if strings.Contains(p, "/") {
+			_, ipNet, err := net.ParseCIDR(p)
+			if err != nil {
+				return nil, fmt.Errorf("bad canary peer %s: %w", p, err)
+			}
+			nets = append(nets, ipNet)
+			continue
+		}
+
+		ip := net.ParseIP(p)
+		if ip == nil {
+			return nil, fmt.Errorf("bad canary peer %s", p)
+		}
+		bits := 8 * len(ip.To16())
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return nets, nil
+}
+
+func matchPeer(ctx context.Context, nets []*net.IPNet) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
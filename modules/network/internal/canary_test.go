@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"context"
+	gingrpc "github.com/dan-and-dna/gin-grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"testing"
+)
+
+func versionHandler(version string) gingrpc.Handler {
+	return gingrpc.Handler{HandleProto: func(context.Context, interface{}) (interface{}, error) { return version, nil }}
+}
+
+func TestCanarySkipsMissingVersion(t *testing.T) {
+	network := newTestNetwork()
+	if err := network.HandleProto("pkg", "Service", "Method", nil, versionHandler("default")); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.HandleProtoVersion("pkg", "Service", "Method", "v2", versionHandler("v2")); err != nil {
+		t.Fatal(err)
+	}
+	// 第一条规则的版本没有注册，交给第二条
+	err := network.SetCanaryRules("pkg", "Service", "Method",
+		CanaryRule{Version: "v3", Header: "x-canary"},
+		CanaryRule{Version: "v2", Header: "x-canary"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler, _ := network.grpcRouteOption.GetHandler("/pkg.Service/Method")
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-canary", "1"))
+	if resp, _ := handler(ctx, nil); resp != "v2" {
+		t.Fatalf("resp = %v, want v2", resp)
+	}
+
+	routes := network.Routes()
+	if len(routes) != 1 || len(routes[0].Versions) != 1 || len(routes[0].CanaryRules) != 2 {
+		t.Fatalf("routes = %+v", routes)
+	}
+
+	// 取消注册后版本和规则一起去掉
+	network.StopHandleProto("pkg", "Service", "Method")
+	if _, ok := network.canaries.Get("/pkg.Service/Method"); ok {
+		t.Fatal("canary kept after StopHandleProto")
+	}
+	if err := network.HandleProto("pkg", "Service", "Method", nil, versionHandler("default")); err != nil {
+		t.Fatal(err)
+	}
+	handler, _ = network.grpcRouteOption.GetHandler("/pkg.Service/Method")
+	if resp, _ := handler(ctx, nil); resp != "default" {
+		t.Fatalf("resp = %v, want default", resp)
+	}
+}
+
+func TestCanaryUsesRegisteredFullMethod(t *testing.T) {
+	network := newTestNetwork()
+	network.core.CaseSensitive = true
+	if err := network.Recreate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 注册前设置的规则也跟着注册的完整方法名走
+	if err := network.SetCanaryRules("grpc.health.v1", "health", "check", CanaryRule{Version: "v2", Header: "x-canary"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.HandleProto("grpc.health.v1", "health", "check", &grpc_health_v1.Health_ServiceDesc, versionHandler("default")); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.HandleProtoVersion("grpc.health.v1", "health", "check", "v2", versionHandler("v2")); err != nil {
+		t.Fatal(err)
+	}
+
+	handler, _ := network.grpcRouteOption.GetHandler("/grpc.health.v1.Health/Check")
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-canary", "1"))
+	if resp, _ := handler(ctx, nil); resp != "v2" {
+		t.Fatalf("resp = %v, want v2", resp)
+	}
+
+	network.StopHandleProto("grpc.health.v1", "health", "check")
+	if _, ok := network.canaries.Get("/grpc.health.v1.Health/Check"); ok {
+		t.Fatal("canary kept after StopHandleProto")
+	}
+}
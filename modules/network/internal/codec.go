@@ -0,0 +1,299 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/dan-and-dna/gin-grpc-network/core"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// http支持的协议格式
+const (
+	MIMEJSON          = "application/json"
+	MIMEProtobuf      = "application/x-protobuf"
+	MIMEProtobufAlias = "application/protobuf"
+	MIMEForm          = "application/x-www-form-urlencoded"
+	MIMEMultipartForm = "multipart/form-data"
+)
+
+// 最大的 multipart 表单内存
+const maxMultipartMemory = 32 << 20
+
+// http的json编码设置
+type jsonCodec struct {
+	protojson bool // 设置了编码选项，回复用protojson编码
+	marshal   protojson.MarshalOptions
+	unmarshal protojson.UnmarshalOptions
+	envelope  core.Envelope
+}
+
+// 没有设置编码选项时回复沿用 encoding/json
+func newJsonCodec(options *core.JsonOptions, envelope core.Envelope) *jsonCodec {
+	codec := &jsonCodec{envelope: envelope}
+	if options == nil {
+		return codec
+	}
+
+	codec.protojson = true
+	codec.marshal = protojson.MarshalOptions{
+		EmitUnpopulated: options.EmitUnpopulated,
+		UseProtoNames:   options.UseProtoNames,
+		UseEnumNumbers:  options.UseEnumNumbers,
+	}
+	codec.unmarshal = protojson.UnmarshalOptions{
+		DiscardUnknown: options.DiscardUnknown,
+	}
+	return codec
+}
+
+func isProtobufMIME(mediaType string) bool {
+	return mediaType == MIMEProtobuf || mediaType == MIMEProtobufAlias
+}
+
+// 按 Content-Type 解码请求，不认识的格式按json处理
+func decodeHttpRequest(c *gin.Context, body []byte, req proto.Message, codec *jsonCodec) *status.Status {
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	switch {
+	case isProtobufMIME(mediaType):
+		if err := proto.Unmarshal(body, req); err != nil {
+			return status.New(codes.InvalidArgument, "bad protobuf")
+		}
+	case mediaType == MIMEForm || mediaType == MIMEMultipartForm:
+		values, err := formValues(c, mediaType, body)
+		if err == nil {
+			err = setFormValues(req.ProtoReflect(), values)
+		}
+		if err != nil {
+			return status.New(codes.InvalidArgument, "bad form: "+err.Error())
+		}
+	default:
+		if codec.envelope != nil {
+			var err error
+			if body, err = codec.envelope.Unwrap(c, body); err != nil {
+				return status.New(codes.InvalidArgument, "bad envelope: "+err.Error())
+			}
+		}
+		if err := codec.unmarshal.Unmarshal(body, req); err != nil {
+			return status.New(codes.InvalidArgument, "bad json")
+		}
+	}
+
+	return nil
+}
+
+// 按 Accept 选择回复的格式，没有 Accept 或者只命中通配时和请求的格式一致
+func wantsProtobuf(c *gin.Context) bool {
+	accept := c.GetHeader("Accept")
+	if accept == "" {
+		return requestIsProtobuf(c)
+	}
+
+	// 选q值最大的，相同时选在前面的
+	best, bestQ := "", -1.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		if !isProtobufMIME(mediaType) && mediaType != MIMEJSON && mediaType != "*/*" && mediaType != "application/*" {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = mediaType, q
+		}
+	}
+
+	if bestQ <= 0 {
+		return false
+	}
+	if best == "*/*" || best == "application/*" {
+		return requestIsProtobuf(c)
+	}
+	return isProtobufMIME(best)
+}
+
+func requestIsProtobuf(c *gin.Context) bool {
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	return isProtobufMIME(mediaType)
+}
+
+// 返回回复
+func renderHttpResponse(c *gin.Context, resp interface{}, codec *jsonCodec) {
+	if wantsProtobuf(c) {
+		message, ok := resp.(proto.Message)
+		if !ok && resp != nil {
+			renderHttpError(c, status.New(codes.Internal, fmt.Sprintf("%T is not protobuf", resp)), codec)
+			return
+		}
+
+		var body []byte
+		if message != nil {
+			var err error
+			if body, err = proto.Marshal(message); err != nil {
+				renderHttpError(c, status.New(codes.Internal, err.Error()), codec)
+				return
+			}
+		}
+		c.Data(http.StatusOK, MIMEProtobuf, body)
+		return
+	}
+
+	body, err := codec.encode(resp)
+	if err != nil {
+		renderHttpError(c, status.New(codes.Internal, err.Error()), codec)
+		return
+	}
+
+	if codec.envelope != nil {
+		c.JSON(http.StatusOK, codec.envelope.Wrap(c, body))
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// 按json编码回复
+func (codec *jsonCodec) encode(resp interface{}) ([]byte, error) {
+	if resp == nil {
+		return []byte("{}"), nil
+	}
+
+	// 处理者返回了带类型的空指针，和没有回复一样返回 {} 而不是 null
+	if message, ok := resp.(proto.Message); ok && !message.ProtoReflect().IsValid() {
+		return []byte("{}"), nil
+	}
+
+	// 设置了编码或者是没有json标签的动态协议，用protojson
+	_, isDynamic := resp.(*dynamicpb.Message)
+	if message, ok := resp.(proto.Message); ok && (codec.protojson || isDynamic) {
+		return codec.marshal.Marshal(message)
+	}
+	return json.Marshal(resp)
+}
+
+// 表单的值，body 已经被读出来了，需要放回去再解析
+func formValues(c *gin.Context, mediaType string, body []byte) (url.Values, error) {
+	if mediaType == MIMEForm {
+		return url.ParseQuery(string(body))
+	}
+
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if err := c.Request.ParseMultipartForm(maxMultipartMemory); err != nil {
+		return nil, err
+	}
+	return c.Request.MultipartForm.Value, nil
+}
+
+// 表单的key为字段名，proto的名字和json的名字都可以，嵌套的字段用 . 连接，比如 user.name
+func setFormValues(message protoreflect.Message, values url.Values) error {
+	for key, vals := range values {
+		if len(vals) == 0 {
+			continue
+		}
+
+		m := message
+		path := strings.Split(key, ".")
+		for _, name := range path[:len(path)-1] {
+			fd := findField(m.Descriptor(), name)
+			if fd == nil || fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+				return fmt.Errorf("unknown field %s", key)
+			}
+			m = m.Mutable(fd).Message()
+		}
+
+		fd := findField(m.Descriptor(), path[len(path)-1])
+		if fd == nil || fd.IsMap() {
+			return fmt.Errorf("unknown field %s", key)
+		}
+
+		if fd.IsList() {
+			list := m.Mutable(fd).List()
+			for _, val := range vals {
+				value, err := parseFormValue(fd, val)
+				if err != nil {
+					return fmt.Errorf("field %s: %w", key, err)
+				}
+				list.Append(value)
+			}
+			continue
+		}
+
+		value, err := parseFormValue(fd, vals[len(vals)-1])
+		if err != nil {
+			return fmt.Errorf("field %s: %w", key, err)
+		}
+		m.Set(fd, value)
+	}
+
+	return nil
+}
+
+func findField(desc protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	if fd := desc.Fields().ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return desc.Fields().ByJSONName(name)
+}
+
+func parseFormValue(fd protoreflect.FieldDescriptor, val string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(val)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(val, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(val, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(val, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(val, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(val, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(val, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(val), nil
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte(val)), nil
+	case protoreflect.EnumKind:
+		if enumValue := fd.Enum().Values().ByName(protoreflect.Name(val)); enumValue != nil {
+			return protoreflect.ValueOfEnum(enumValue.Number()), nil
+		}
+		v, err := strconv.ParseInt(val, 10, 32)
+		if err != nil || v < math.MinInt32 || v > math.MaxInt32 {
+			return protoreflect.Value{}, fmt.Errorf("bad enum %s", val)
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), nil
+	}
+
+	return protoreflect.Value{}, fmt.Errorf("%s is not supported in form", fd.Kind())
+}
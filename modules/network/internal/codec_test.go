@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"encoding/json"
+	"github.com/dan-and-dna/gin-grpc-network/core"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestWantsProtobuf(t *testing.T) {
+	cases := []struct {
+		accept      string
+		contentType string
+		want        bool
+	}{
+		{"", MIMEProtobuf, true},
+		{"", MIMEJSON, false},
+		{MIMEProtobuf, MIMEJSON, true},
+		{MIMEJSON, MIMEProtobuf, false},
+		// 只命中通配时和请求的格式一致
+		{"*/*", MIMEProtobuf, true},
+		{"application/*", MIMEProtobuf, true},
+		{"*/*", MIMEJSON, false},
+		{MIMEJSON + ";q=0.5, " + MIMEProtobuf, MIMEJSON, true},
+		{MIMEProtobuf + ";q=0", MIMEProtobuf, false},
+	}
+
+	for _, c := range cases {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest("POST", "/", nil)
+		ctx.Request.Header.Set("Accept", c.accept)
+		ctx.Request.Header.Set("Content-Type", c.contentType)
+		if got := wantsProtobuf(ctx); got != c.want {
+			t.Errorf("accept %q content-type %q: got %v, want %v", c.accept, c.contentType, got, c.want)
+		}
+	}
+}
+
+// message JsonMsg { int64 big_id = 1; Kind kind = 2; string user_name = 3; }
+func testJsonMessage(t *testing.T) protoreflect.MessageDescriptor {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("json.proto"),
+		Package: proto.String("json"),
+		Syntax:  proto.String("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Kind"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("KIND_UNKNOWN"), Number: proto.Int32(0)},
+				{Name: proto.String("KIND_A"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("JsonMsg"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("big_id"), JsonName: proto.String("bigId"), Number: proto.Int32(1), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()},
+				{Name: proto.String("kind"), JsonName: proto.String("kind"), Number: proto.Int32(2), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum(), TypeName: proto.String(".json.Kind")},
+				{Name: proto.String("user_name"), JsonName: proto.String("userName"), Number: proto.Int32(3), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+			},
+		}},
+	}
+
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fd.Messages().ByName("JsonMsg")
+}
+
+func TestJsonOptionsRoundTrip(t *testing.T) {
+	desc := testJsonMessage(t)
+	newMsg := func(bigId int64, kind protoreflect.EnumNumber, userName string) *dynamicpb.Message {
+		msg := dynamicpb.NewMessage(desc)
+		if bigId != 0 {
+			msg.Set(desc.Fields().ByName("big_id"), protoreflect.ValueOfInt64(bigId))
+		}
+		if kind != 0 {
+			msg.Set(desc.Fields().ByName("kind"), protoreflect.ValueOfEnum(kind))
+		}
+		if userName != "" {
+			msg.Set(desc.Fields().ByName("user_name"), protoreflect.ValueOfString(userName))
+		}
+		return msg
+	}
+
+	cases := []struct {
+		name    string
+		options core.JsonOptions
+		msg     *dynamicpb.Message
+		want    map[string]interface{}
+	}{
+		// int64 总是输出为字符串，超过 2^53 也不丢精度
+		{"default", core.JsonOptions{}, newMsg(1<<60, 1, "a"), map[string]interface{}{"bigId": "1152921504606846976", "kind": "KIND_A", "userName": "a"}},
+		{"emit unpopulated", core.JsonOptions{EmitUnpopulated: true}, newMsg(0, 0, ""), map[string]interface{}{"bigId": "0", "kind": "KIND_UNKNOWN", "userName": ""}},
+		{"proto names", core.JsonOptions{UseProtoNames: true}, newMsg(-5, 0, "a"), map[string]interface{}{"big_id": "-5", "user_name": "a"}},
+		{"enum numbers", core.JsonOptions{UseEnumNumbers: true}, newMsg(0, 1, ""), map[string]interface{}{"kind": float64(1)}},
+	}
+
+	for _, c := range cases {
+		codec := newJsonCodec(&c.options, nil)
+		body, err := codec.encode(c.msg)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		// protojson 的空白不固定，按json比较
+		var got map[string]interface{}
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("%s: got %v, want %v", c.name, got, c.want)
+		}
+
+		decoded := dynamicpb.NewMessage(desc)
+		if err := codec.unmarshal.Unmarshal(body, decoded); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !proto.Equal(decoded, c.msg) {
+			t.Fatalf("%s: decoded %v, want %v", c.name, decoded, c.msg)
+		}
+	}
+}
+
+func TestJsonOptionsDecode(t *testing.T) {
+	desc := testJsonMessage(t)
+
+	// int64 解码时字符串和数字都接受
+	codec := newJsonCodec(&core.JsonOptions{}, nil)
+	for _, body := range []string{`{"bigId":"7"}`, `{"bigId":7}`, `{"big_id":7}`} {
+		msg := dynamicpb.NewMessage(desc)
+		if err := codec.unmarshal.Unmarshal([]byte(body), msg); err != nil {
+			t.Fatalf("%s: %v", body, err)
+		}
+		if got := msg.Get(desc.Fields().ByName("big_id")).Int(); got != 7 {
+			t.Fatalf("%s: big_id = %d", body, got)
+		}
+	}
+
+	unknown := []byte(`{"bigId":"7","extra":1}`)
+	if err := codec.unmarshal.Unmarshal(unknown, dynamicpb.NewMessage(desc)); err == nil {
+		t.Fatal("unknown field accepted without DiscardUnknown")
+	}
+	codec = newJsonCodec(&core.JsonOptions{DiscardUnknown: true}, nil)
+	if err := codec.unmarshal.Unmarshal(unknown, dynamicpb.NewMessage(desc)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJsonWithoutOptions(t *testing.T) {
+	// 没有设置编码选项时沿用 encoding/json，int64 输出为数字
+	body, err := newJsonCodec(nil, nil).encode(&descriptorpb.UninterpretedOption{NegativeIntValue: proto.Int64(-5)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"negative_int_value":-5}` {
+		t.Fatalf("body = %s", body)
+	}
+}
+
+func TestJsonTypedNil(t *testing.T) {
+	for _, options := range []*core.JsonOptions{nil, {}} {
+		body, err := newJsonCodec(options, nil).encode((*grpc_health_v1.HealthCheckResponse)(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "{}" {
+			t.Fatalf("json options %v: body = %s", options, body)
+		}
+	}
+}
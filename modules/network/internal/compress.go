@@ -0,0 +1,299 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/dan-and-dna/gin-grpc-network/core"
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// 支持的压缩算法
+const (
+	EncodingGzip   = "gzip"
+	EncodingZstd   = "zstd"
+	EncodingBrotli = "br"
+)
+
+// 回复达到这个大小才压缩
+const defaultCompressMinSize = 1024
+
+// 解压后的最大大小，防止压缩炸弹
+const maxDecompressSize = 64 << 20
+
+var (
+	// EncodeAll 和 DecodeAll 可以并发调用，共享一个
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressSize))
+)
+
+// 全局注册，进程里所有的grpc服务端和客户端都能用zstd，gzip 由 grpc 注册，grpc 不支持 br
+// 业务已经注册了zstd时沿用业务的，不覆盖；业务在之后的 init 里注册会覆盖这里的
+func init() {
+	if encoding.GetCompressor(EncodingZstd) == nil {
+		encoding.RegisterCompressor(zstdCompressor{})
+	}
+}
+
+// grpc的zstd压缩，一条消息整体压缩
+type zstdCompressor struct{}
+
+func (zstdCompressor) Name() string {
+	return EncodingZstd
+}
+
+func (zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return &zstdWriter{w: w}, nil
+}
+
+func (zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxDecompressSize))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = zstdDecoder.DecodeAll(data, nil)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// 先缓存，关闭时整体压缩
+type zstdWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (writer *zstdWriter) Write(p []byte) (int, error) {
+	writer.buf = append(writer.buf, p...)
+	return len(p), nil
+}
+
+func (writer *zstdWriter) Close() error {
+	_, err := writer.w.Write(zstdEncoder.EncodeAll(writer.buf, nil))
+	return err
+}
+
+// 检查grpc的压缩算法
+func checkGrpcCompressor(name string) error {
+	if name != "" && encoding.GetCompressor(name) == nil {
+		return fmt.Errorf("unknown grpc compressor: %s", name)
+	}
+	return nil
+}
+
+// grpc服务端回复的默认压缩设置，为空时按客户端请求使用的算法压缩
+func (network *Network) serverCompressOptions() []grpc.ServerOption {
+	name := network.core.GrpcServerCompressor
+	if name == "" {
+		return nil
+	}
+	return []grpc.ServerOption{grpc.RPCCompressor(legacyCompressor{encoding.GetCompressor(name)})}
+}
+
+// grpc-go v1.50 只能用旧的 grpc.Compressor 设置服务端默认的压缩算法
+type legacyCompressor struct {
+	encoding.Compressor
+}
+
+func (c legacyCompressor) Do(w io.Writer, p []byte) error {
+	z, err := c.Compress(w)
+	if err != nil {
+		return err
+	}
+	if _, err := z.Write(p); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+func (c legacyCompressor) Type() string {
+	return c.Name()
+}
+
+// 调用上游(代理、转发、镜像)时的压缩设置
+func (network *Network) compressOptions() []grpc.CallOption {
+	if name, _ := network.grpcCompressor.Load().(string); name != "" {
+		return []grpc.CallOption{grpc.UseCompressor(name)}
+	}
+	return nil
+}
+
+// http回复的压缩设置
+type compression struct {
+	encodings []string
+	minSize   int
+}
+
+// 没有设置时返回空，不压缩
+func newCompression(options *core.CompressionOptions) *compression {
+	if options == nil {
+		return nil
+	}
+
+	c := &compression{encodings: options.Encodings, minSize: options.MinSize}
+	if len(c.encodings) == 0 {
+		c.encodings = []string{EncodingZstd, EncodingBrotli, EncodingGzip}
+	}
+	if c.minSize <= 0 {
+		c.minSize = defaultCompressMinSize
+	}
+	return c
+}
+
+func checkCompression(options *core.CompressionOptions) error {
+	if options == nil {
+		return nil
+	}
+
+	for _, name := range options.Encodings {
+		switch name {
+		case EncodingGzip, EncodingZstd, EncodingBrotli:
+		default:
+			return fmt.Errorf("unknown http encoding: %s", name)
+		}
+	}
+	return nil
+}
+
+// 按 Accept-Encoding 选择压缩算法，q值相同时按设置的顺序
+func (c *compression) negotiate(acceptEncoding string) string {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64); err != nil {
+				continue
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, name := range c.encodings {
+		q, ok := accepted[name]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// 缓存回复，处理完后按大小决定是否压缩
+type compressWriter struct {
+	gin.ResponseWriter
+	buf    bytes.Buffer
+	status int
+}
+
+func (writer *compressWriter) WriteHeader(code int) {
+	if code > 0 {
+		writer.status = code
+	}
+}
+
+func (writer *compressWriter) WriteHeaderNow() {}
+
+func (writer *compressWriter) Write(data []byte) (int, error) {
+	return writer.buf.Write(data)
+}
+
+func (writer *compressWriter) WriteString(s string) (int, error) {
+	return writer.buf.WriteString(s)
+}
+
+func (writer *compressWriter) Status() int {
+	return writer.status
+}
+
+func (writer *compressWriter) Size() int {
+	return writer.buf.Len()
+}
+
+func (writer *compressWriter) Written() bool {
+	return writer.buf.Len() > 0
+}
+
+// 包装回复，返回的函数需要直接 defer 调用
+func (c *compression) wrap(ctx *gin.Context) func() {
+	if c == nil || ctx.Request.Method == http.MethodHead {
+		return func() {}
+	}
+
+	name := c.negotiate(ctx.GetHeader("Accept-Encoding"))
+	if name == "" {
+		return func() {}
+	}
+
+	origin := ctx.Writer
+	writer := &compressWriter{ResponseWriter: origin, status: http.StatusOK}
+	ctx.Writer = writer
+
+	return func() {
+		ctx.Writer = origin
+
+		// 处理时 panic 了，丢掉缓存的回复，交给上层的 Recovery
+		if r := recover(); r != nil {
+			panic(r)
+		}
+
+		body := writer.buf.Bytes()
+		header := origin.Header()
+		header.Add("Vary", "Accept-Encoding")
+
+		if len(body) >= c.minSize && header.Get("Content-Encoding") == "" {
+			compressed, err := compressBody(name, body)
+			if err == nil {
+				header.Set("Content-Encoding", name)
+				header.Del("Content-Length")
+				body = compressed
+			} else {
+				log.Printf("[network] failed to compress response with %s: %v\n", name, err)
+			}
+		}
+
+		origin.WriteHeader(writer.status)
+		origin.Write(body)
+	}
+}
+
+func compressBody(name string, body []byte) ([]byte, error) {
+	if name == EncodingZstd {
+		return zstdEncoder.EncodeAll(body, nil), nil
+	}
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch name {
+	case EncodingGzip:
+		w = gzip.NewWriter(&buf)
+	case EncodingBrotli:
+		w = brotli.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unknown encoding %s", name)
+	}
+
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
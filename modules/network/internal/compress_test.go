@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"context"
+	"github.com/dan-and-dna/gin-grpc-network/core"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/stats"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressionNegotiate(t *testing.T) {
+	c := newCompression(&core.CompressionOptions{})
+	cases := map[string]string{
+		"":                     "",
+		"identity":             "",
+		"gzip":                 EncodingGzip,
+		"gzip;q=0.5, br":       EncodingBrotli,
+		"gzip, br, zstd":       EncodingZstd,
+		"*":                    EncodingZstd,
+		"zstd;q=0, gzip;q=0.1": EncodingGzip,
+		"GZIP":                 EncodingGzip,
+	}
+	for acceptEncoding, want := range cases {
+		if got := c.negotiate(acceptEncoding); got != want {
+			t.Errorf("negotiate(%q) = %q, want %q", acceptEncoding, got, want)
+		}
+	}
+}
+
+func TestCompressionPanic(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	c := newCompression(&core.CompressionOptions{MinSize: 1})
+	router := gin.New()
+	router.Use(gin.CustomRecovery(func(ctx *gin.Context, err interface{}) {
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+	}))
+	router.GET("/", func(ctx *gin.Context) {
+		defer c.wrap(ctx)()
+		ctx.String(http.StatusOK, "partial")
+		panic("boom")
+	})
+	router.GET("/ok", func(ctx *gin.Context) {
+		defer c.wrap(ctx)()
+		ctx.String(http.StatusOK, strings.Repeat("a", 100))
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError || w.Body.Len() != 0 {
+		t.Fatalf("panic answered %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/ok", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Fatalf("answered %d %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+}
+
+// 记录回复使用的压缩算法
+type compressionRecorder struct {
+	compression chan string
+}
+
+func (r *compressionRecorder) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (r *compressionRecorder) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if header, ok := s.(*stats.InHeader); ok {
+		r.compression <- header.Compression
+	}
+}
+
+func (r *compressionRecorder) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (r *compressionRecorder) HandleConn(context.Context, stats.ConnStats) {}
+
+func TestGrpcServerCompressor(t *testing.T) {
+	for _, name := range []string{"", EncodingGzip, EncodingZstd} {
+		network := newTestNetwork()
+		if err := network.HandleProto("grpc.health.v1", "Health", "Check", nil, servingHandler()); err != nil {
+			t.Fatal(err)
+		}
+		network.core.GrpcServerCompressor = name
+		target := startTestGrpc(t, network).Target()
+
+		// 客户端不压缩请求，回复按服务端的设置压缩
+		recorder := &compressionRecorder{compression: make(chan string, 1)}
+		conn, err := grpc.Dial(target, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithStatsHandler(recorder))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		resp, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+		if err != nil {
+			t.Fatalf("%q: %v", name, err)
+		}
+		if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+			t.Fatalf("%q: status = %v", name, resp.Status)
+		}
+		if got := <-recorder.compression; got != name {
+			t.Fatalf("response compression = %q, want %q", got, name)
+		}
+	}
+}
+
+func TestCheckGrpcServerCompressor(t *testing.T) {
+	if err := validateConfig(&core.NetworkCore{GrpcServerCompressor: EncodingBrotli}); err == nil {
+		t.Fatal("br is not a grpc compressor")
+	}
+}
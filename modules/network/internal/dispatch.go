@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"context"
+	gingrpc "github.com/dan-and-dna/gin-grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// grpc的路由中间件，放在全局中间件的最后，没有处理者时转发给上游
+func (network *Network) routeUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, _ grpc.UnaryHandler) (interface{}, error) {
+	if handler, ok := network.grpcRouteOption.GetHandler(info.FullMethod); ok {
+		return handler(ctx, req)
+	}
+
+	if proxy := network.proxy.Load(); proxy != nil {
+		return proxy.Invoke(ctx, info.FullMethod, req)
+	}
+
+	return nil, status.Errorf(codes.NotFound, "%s: no service can help you", info.FullMethod)
+}
+
+// 流的路由中间件，启动后才注册的方法grpc不认识，也按流从这里进来
+func (network *Network) routeStream(_ interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, _ grpc.StreamHandler) error {
+	if listener, ok := network.grpcRouteOptionStream.GetHandler(info.FullMethod); ok {
+		return listener(ss)
+	}
+
+	if handler, ok := network.ginGrpcOption.GetHandler(info.FullMethod); ok && handler.Proto != nil {
+		return network.serveUnaryStream(ss, info.FullMethod, handler)
+	}
+
+	if proxy := network.proxy.Load(); proxy != nil {
+		return proxy.ServeStream(ss, info.FullMethod)
+	}
+
+	return status.Errorf(codes.NotFound, "%s: no service can help you", info.FullMethod)
+}
+
+// 按普通方法处理一个流：收一个请求，回一个回复
+// 和启动前注册的方法一样经过全局的普通中间件，鉴权等不受注册时机影响
+func (network *Network) serveUnaryStream(ss grpc.ServerStream, fullMethod string, handler *gingrpc.Handler) error {
+	req := proto.Clone(handler.Proto)
+	if err := ss.RecvMsg(req); err != nil {
+		return err
+	}
+
+	resp, err := network.callUnary(ss.Context(), fullMethod, req, handler.HandleProto)
+	if err != nil {
+		return err
+	}
+
+	return ss.SendMsg(resp)
+}
+
+// 不认识的服务和方法，路由中间件已经处理过，走到这里说明没有处理者
+func (network *Network) unknownService(_ interface{}, ss grpc.ServerStream) error {
+	fullMethod, _ := grpc.MethodFromServerStream(ss)
+	return status.Errorf(codes.NotFound, "%s: no service can help you", fullMethod)
+}
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"context"
+	gingrpc "github.com/dan-and-dna/gin-grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"testing"
+)
+
+// 按grpc启动，返回连接这个服务的客户端
+func startTestGrpc(t *testing.T, network *Network, middlewares ...grpc.UnaryServerInterceptor) *grpc.ClientConn {
+	network.core.ListenGrpc = true
+	network.core.ListenIp = "127.0.0.1"
+	network.core.GrpcMiddlewares = middlewares
+	if err := network.Recreate(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, lis := network.grpcSrv, network.grpcListener
+	go srv.Serve(lis)
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Stop()
+	})
+	return conn
+}
+
+func servingHandler() gingrpc.Handler {
+	return gingrpc.Handler{
+		Proto: &grpc_health_v1.HealthCheckRequest{},
+		HandleProto: func(ctx context.Context, req interface{}) (interface{}, error) {
+			return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+		},
+	}
+}
+
+// 只收发一个消息的流
+type testStream struct {
+	grpc.ServerStream
+	req  proto.Message
+	sent []interface{}
+}
+
+func (ss *testStream) Context() context.Context {
+	return context.Background()
+}
+
+func (ss *testStream) RecvMsg(m interface{}) error {
+	proto.Merge(m.(proto.Message), ss.req)
+	return nil
+}
+
+func (ss *testStream) SendMsg(m interface{}) error {
+	ss.sent = append(ss.sent, m)
+	return nil
+}
+
+func TestRouteStreamUnaryRunsMiddlewares(t *testing.T) {
+	network := newTestNetwork()
+	if err := network.Recreate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var global, route int
+	network.setUnaryChain([]grpc.UnaryServerInterceptor{func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		global++
+		return handler(ctx, req)
+	}})
+
+	err := network.HandleProto("grpc.health.v1", "Health", "Check", nil, gingrpc.Handler{
+		Proto: &grpc_health_v1.HealthCheckRequest{},
+		HandleProto: func(ctx context.Context, req interface{}) (interface{}, error) {
+			return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+		},
+	}, func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		route++
+		return handler(ctx, req)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 启动后注册的普通方法，grpc按流交给路由中间件
+	ss := &testStream{req: &grpc_health_v1.HealthCheckRequest{Service: "a"}}
+	info := &grpc.StreamServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}
+	if err := network.routeStream(nil, ss, info, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ss.sent) != 1 {
+		t.Fatalf("sent %d messages", len(ss.sent))
+	}
+	if global != 1 || route != 1 {
+		t.Fatalf("global unary chain ran %d times, route middlewares ran %d times", global, route)
+	}
+}
+
+func TestGlobalMiddlewareRejectsHotAddedMethod(t *testing.T) {
+	network := newTestNetwork()
+	conn := startTestGrpc(t, network, func(context.Context, interface{}, *grpc.UnaryServerInfo, grpc.UnaryHandler) (interface{}, error) {
+		return nil, status.Error(codes.Unauthenticated, "no token")
+	})
+
+	// 启动后才注册，grpc不认识这个服务，按流进来
+	if err := network.HandleProto("grpc.health.v1", "Health", "Check", nil, servingHandler()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("err = %v, want Unauthenticated", err)
+	}
+}
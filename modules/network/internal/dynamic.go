@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"github.com/bufbuild/protocompile"
+	gingrpc "github.com/dan-and-dna/gin-grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// 动态处理者，请求是按描述文件生成的动态协议，回复可以是动态协议也可以是生成的协议
+type DynamicHandler func(ctx context.Context, req *dynamicpb.Message) (proto.Message, error)
+
+// 从 FileDescriptorSet 加载协议，比如 protoc --include_imports --descriptor_set_out 的输出
+func LoadFileDescriptorSet(data []byte) (*protoregistry.Files, error) {
+	set := new(descriptorpb.FileDescriptorSet)
+	if err := proto.Unmarshal(data, set); err != nil {
+		return nil, fmt.Errorf("bad file descriptor set: %w", err)
+	}
+
+	return protodesc.NewFiles(set)
+}
+
+// 从 .proto 文件加载协议，importPaths 为查找 import 的目录，google/protobuf 下的标准协议不需要提供
+func LoadProtoFiles(ctx context.Context, importPaths []string, files ...string) (*protoregistry.Files, error) {
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{ImportPaths: importPaths}),
+	}
+
+	compiled, err := compiler.Compile(ctx, files...)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := new(protoregistry.Files)
+	for _, file := range compiled {
+		if err := registerFile(registry, file); err != nil {
+			return nil, err
+		}
+	}
+
+	return registry, nil
+}
+
+// 依赖的文件先注册
+func registerFile(registry *protoregistry.Files, file protoreflect.FileDescriptor) error {
+	if _, err := registry.FindFileByPath(file.Path()); err == nil {
+		return nil
+	}
+
+	imports := file.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		if err := registerFile(registry, imports.Get(i).FileDescriptor); err != nil {
+			return err
+		}
+	}
+
+	return registry.RegisterFile(file)
+}
+
+// 注册动态处理者，http和grpc都由它处理，不需要生成代码
+func (network *Network) HandleDynamic(files *protoregistry.Files, pkg, service, method string, handler DynamicHandler, middlewares ...grpc.UnaryServerInterceptor) error {
+	if files == nil || handler == nil {
+		return network.rejectRegister(fmt.Errorf("%w: files and handler must not be nil", ErrBadRegister))
+	}
+
+	serviceDesc, err := findServiceDescriptor(files, pkg+"."+service)
+	if err != nil {
+		return network.rejectRegister(fmt.Errorf("%w: %v", ErrMethodNotInDesc, err))
+	}
+
+	methodDesc := serviceDesc.Methods().ByName(protoreflect.Name(method))
+	if methodDesc == nil {
+		return network.rejectRegister(fmt.Errorf("%w: %s not in %s", ErrMethodNotInDesc, method, serviceDesc.FullName()))
+	}
+
+	if methodDesc.IsStreamingClient() || methodDesc.IsStreamingServer() {
+		return network.rejectRegister(fmt.Errorf("%w: %s/%s is stream", ErrRegisterConflict, serviceDesc.FullName(), method))
+	}
+
+	input, output := methodDesc.Input(), methodDesc.Output()
+	fullMethod := "/" + string(serviceDesc.FullName()) + "/" + method
+
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	return network.register(&registration{
+		pkg:         pkg,
+		service:     service,
+		method:      method,
+		desc:        dynamicServiceDesc(serviceDesc),
+		middlewares: middlewares,
+		respType:    string(output.FullName()),
+		handler: &gingrpc.Handler{
+			Proto: dynamicpb.NewMessage(input),
+			HandleProto: func(ctx context.Context, rawReq interface{}) (interface{}, error) {
+				req, ok := rawReq.(*dynamicpb.Message)
+				if !ok || req.Descriptor().FullName() != input.FullName() {
+					return nil, &TypeMismatchError{Key: fullMethod, Want: string(input.FullName()), Got: fmt.Sprintf("%T", rawReq)}
+				}
+
+				resp, err := handler(ctx, req)
+				if err != nil {
+					return nil, err
+				}
+
+				if resp == nil || resp.ProtoReflect().Descriptor().FullName() != output.FullName() {
+					return nil, status.Errorf(codes.Internal, "%s: want response %s", fullMethod, output.FullName())
+				}
+				return resp, nil
+			},
+		},
+	})
+}
+
+// 按描述文件生成grpc的服务描述，重启后grpc直接认识这个服务
+func dynamicServiceDesc(serviceDesc protoreflect.ServiceDescriptor) *grpc.ServiceDesc {
+	desc := &grpc.ServiceDesc{
+		ServiceName: string(serviceDesc.FullName()),
+		HandlerType: (*interface{})(nil),
+		Metadata:    serviceDesc.ParentFile().Path(),
+	}
+
+	methods := serviceDesc.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		method := methods.Get(i)
+		if method.IsStreamingClient() || method.IsStreamingServer() {
+			desc.Streams = append(desc.Streams, grpc.StreamDesc{
+				StreamName:    string(method.Name()),
+				Handler:       unimplementedStream,
+				ClientStreams: method.IsStreamingClient(),
+				ServerStreams: method.IsStreamingServer(),
+			})
+			continue
+		}
+
+		desc.Methods = append(desc.Methods, grpc.MethodDesc{
+			MethodName: string(method.Name()),
+			Handler:    dynamicMethodHandler(desc.ServiceName, method),
+		})
+	}
+
+	return desc
+}
+
+// 解码成动态协议后交给路由中间件
+func dynamicMethodHandler(serviceName string, method protoreflect.MethodDescriptor) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/" + serviceName + "/" + string(method.Name())}
+
+	return func(_ interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := dynamicpb.NewMessage(method.Input())
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+
+		if interceptor == nil {
+			return nil, status.Errorf(codes.Unimplemented, "%s: no service can help you", info.FullMethod)
+		}
+		return interceptor(ctx, req, info, func(context.Context, interface{}) (interface{}, error) {
+			return nil, status.Errorf(codes.Unimplemented, "%s: no service can help you", info.FullMethod)
+		})
+	}
+}
+
+// 流由路由中间件处理，走到这里说明没有注册
+func unimplementedStream(_ interface{}, ss grpc.ServerStream) error {
+	fullMethod, _ := grpc.MethodFromServerStream(ss)
+	return status.Errorf(codes.Unimplemented, "%s: no service can help you", fullMethod)
+}
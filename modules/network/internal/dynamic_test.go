@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// package dyn; service Greeter { rpc Say(HelloReq) returns (HelloResp); rpc Watch(HelloReq) returns (stream HelloResp); }
+func testGreeterFile() *descriptorpb.FileDescriptorProto {
+	stringField := func(name string) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(1),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+		}
+	}
+
+	return &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("dyn.proto"),
+		Package: proto.String("dyn"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("HelloReq"), Field: []*descriptorpb.FieldDescriptorProto{stringField("name")}},
+			{Name: proto.String("HelloResp"), Field: []*descriptorpb.FieldDescriptorProto{stringField("message")}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Greeter"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{Name: proto.String("Say"), InputType: proto.String(".dyn.HelloReq"), OutputType: proto.String(".dyn.HelloResp")},
+				{Name: proto.String("Watch"), InputType: proto.String(".dyn.HelloReq"), OutputType: proto.String(".dyn.HelloResp"), ServerStreaming: proto.Bool(true)},
+			},
+		}},
+	}
+}
+
+func loadGreeterFiles(t *testing.T) *protoregistry.Files {
+	data, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{testGreeterFile()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := LoadFileDescriptorSet(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestHandleDynamic(t *testing.T) {
+	files := loadGreeterFiles(t)
+	desc, err := files.FindDescriptorByName("dyn.HelloResp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	respDesc := desc.(protoreflect.MessageDescriptor)
+
+	network := newTestNetwork()
+	err = network.HandleDynamic(files, "dyn", "Greeter", "Say", func(ctx context.Context, req *dynamicpb.Message) (proto.Message, error) {
+		name := req.Get(req.Descriptor().Fields().ByName("name")).String()
+		if name == "" {
+			// 回复的类型不对
+			return req, nil
+		}
+
+		resp := dynamicpb.NewMessage(respDesc)
+		resp.Set(respDesc.Fields().ByName("message"), protoreflect.ValueOfString("hello "+name))
+		return resp, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// http按 protojson 编解码
+	router := newTestRouter(t, network)
+	cases := []struct {
+		path   string
+		body   string
+		status int
+		want   string
+	}{
+		{"/dyn/Greeter/Say", `{"name":"bob"}`, http.StatusOK, `"message":"hello bob"`},
+		{"/dyn/Greeter/Say", `{}`, http.StatusInternalServerError, `want response dyn.HelloResp`},
+		{"/dyn/Greeter/Nope", `{}`, http.StatusNotFound, `"error_desc":"NotFound"`},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(c.body))
+		r.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, r)
+		if w.Code != c.status || !strings.Contains(w.Body.String(), c.want) {
+			t.Errorf("%s %s: %d %s", c.path, c.body, w.Code, w.Body.String())
+		}
+	}
+
+	// grpc 用 dynamicpb 编解码
+	conn := startTestGrpc(t, network)
+	reqDesc := respDesc.ParentFile().Messages().ByName("HelloReq")
+	req := dynamicpb.NewMessage(reqDesc)
+	req.Set(reqDesc.Fields().ByName("name"), protoreflect.ValueOfString("alice"))
+	resp := dynamicpb.NewMessage(respDesc)
+	if err := conn.Invoke(context.Background(), "/dyn.Greeter/Say", req, resp); err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Get(respDesc.Fields().ByName("message")).String(); got != "hello alice" {
+		t.Fatalf("grpc: message = %q", got)
+	}
+
+	err = conn.Invoke(context.Background(), "/dyn.Greeter/Nope", req, dynamicpb.NewMessage(respDesc))
+	if code := status.Code(err); code != codes.NotFound {
+		t.Fatalf("grpc unknown method: %v", err)
+	}
+}
+
+func TestHandleDynamicRejects(t *testing.T) {
+	files := loadGreeterFiles(t)
+	network := newTestNetwork()
+	handler := func(context.Context, *dynamicpb.Message) (proto.Message, error) { return nil, nil }
+
+	if err := network.HandleDynamic(files, "dyn", "Greeter", "Nope", handler); !errors.Is(err, ErrMethodNotInDesc) {
+		t.Fatalf("unknown method: %v", err)
+	}
+	if err := network.HandleDynamic(files, "dyn", "Greeter", "Watch", handler); !errors.Is(err, ErrRegisterConflict) {
+		t.Fatalf("stream method: %v", err)
+	}
+	if len(network.Routes()) != 0 {
+		t.Fatalf("routes after rejected register: %v", network.Routes())
+	}
+}
+
+func TestLoadBadFileDescriptorSet(t *testing.T) {
+	if _, err := LoadFileDescriptorSet([]byte("not a descriptor set")); err == nil {
+		t.Fatal("loaded garbage")
+	}
+
+	// 引用了不存在的类型
+	file := testGreeterFile()
+	file.Service[0].Method[0].InputType = proto.String(".dyn.Missing")
+	data, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFileDescriptorSet(data); err == nil {
+		t.Fatal("loaded a descriptor with an unresolved type")
+	}
+}
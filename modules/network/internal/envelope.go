@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/status"
+	"net/http"
+)
+
+// 常见的 {"code":0,"msg":"ok","data":{...}} 信封，错误时 code 为grpc错误码
+type CodeMsgEnvelope struct {
+	KeepHttpStatus bool // 错误时返回grpc错误码对应的http状态码，否则都返回200
+	UnwrapRequest  bool // 请求也带了信封，有 code 或者 msg 时从 data 里取出请求
+}
+
+type codeMsgBody struct {
+	Code    int               `json:"code"`
+	Msg     string            `json:"msg"`
+	Data    json.RawMessage   `json:"data,omitempty"`
+	Details []json.RawMessage `json:"details,omitempty"`
+}
+
+func (envelope *CodeMsgEnvelope) Unwrap(c *gin.Context, body []byte) ([]byte, error) {
+	if !envelope.UnwrapRequest {
+		return body, nil
+	}
+
+	var request map[string]json.RawMessage
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, err
+	}
+
+	// 只有 data 不算信封，请求自己可能有 data 字段
+	_, hasCode := request["code"]
+	_, hasMsg := request["msg"]
+	data := request["data"]
+	if (!hasCode && !hasMsg) || len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return body, nil
+	}
+	return data, nil
+}
+
+func (envelope *CodeMsgEnvelope) Wrap(c *gin.Context, data json.RawMessage) interface{} {
+	return &codeMsgBody{Msg: "ok", Data: data}
+}
+
+func (envelope *CodeMsgEnvelope) WrapError(c *gin.Context, code int, s *status.Status) (int, interface{}) {
+	if !envelope.KeepHttpStatus {
+		code = http.StatusOK
+	}
+
+	details := StatusDetails(s)
+	if len(details) == 0 {
+		details = nil
+	}
+	return code, &codeMsgBody{Code: int(s.Code()), Msg: s.Message(), Details: details}
+}
@@ -0,0 +1,29 @@
+package internal
+
+import (
+	"testing"
+)
+
+func TestCodeMsgEnvelopeUnwrap(t *testing.T) {
+	envelope := &CodeMsgEnvelope{UnwrapRequest: true}
+	cases := []struct {
+		body string
+		want string
+	}{
+		{`{"code":0,"msg":"","data":{"name":"a"}}`, `{"name":"a"}`},
+		{`{"msg":"","data":{"name":"a"}}`, `{"name":"a"}`},
+		// 请求自己有 data 字段，不是信封
+		{`{"data":{"name":"a"}}`, `{"data":{"name":"a"}}`},
+		{`{"code":0,"data":null}`, `{"code":0,"data":null}`},
+	}
+
+	for _, c := range cases {
+		got, err := envelope.Unwrap(nil, []byte(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != c.want {
+			t.Errorf("unwrap %s = %s, want %s", c.body, got, c.want)
+		}
+	}
+}
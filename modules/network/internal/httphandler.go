@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	gingrpc "github.com/dan-and-dna/gin-grpc"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 和 gingrpc.GinGrpc 一样放在gin上下文里，gin中间件可以读取
+const (
+	GinGrpcReqKey     = "gin_grpc_req"
+	GinGrpcHandlerKey = "gin_grpc_handler"
+	GinGrpcRespKey    = "gin_grpc_resp"
+	GinGrpcErrKey     = "gin_grpc_err"
+)
+
+// http请求转成grpc的处理者，行为和 gingrpc.GinGrpc 一致
+func (network *Network) HttpHandler(ctxOptions ...gingrpc.GrpcCtxOption) gin.HandlerFunc {
+	option := network.ginGrpcOption
+
+	return func(c *gin.Context) {
+		// 按 Accept-Encoding 压缩回复
+		defer network.compression.Load().wrap(c)()
+		codec := network.jsonCodec.Load()
+
+		// 拿协议
+		key := option.PathToGrpcService(c)
+
+		// 填充协议
+		bodyBuffer, err := c.GetRawData()
+		if err != nil {
+			renderHttpError(c, status.New(codes.Internal, err.Error()), codec)
+			return
+		}
+
+		handler, ok := option.GetHandler(key)
+		if !ok {
+			// 和grpc一样返回 NotFound
+			renderHttpError(c, status.Newf(codes.NotFound, "%s: no service can help you", key), codec)
+			return
+		}
+
+		if handler == nil || handler.Proto == nil || handler.HandleProto == nil {
+			return
+		}
+
+		// 按 Content-Type 解码，支持json、protobuf和表单
+		reqProto := proto.Clone(handler.Proto)
+		if s := decodeHttpRequest(c, bodyBuffer, reqProto, codec); s != nil {
+			renderHttpError(c, s, codec)
+			return
+		}
+		c.Set(GinGrpcReqKey, reqProto)
+		c.Set(GinGrpcHandlerKey, handler)
+
+		// http头部作为grpc的metadata
+		var ctx context.Context = c
+		md := metadata.MD{}
+		for key, val := range c.Request.Header {
+			md.Append(key, val...)
+		}
+		ctx = metadata.NewIncomingContext(ctx, md)
+		if addr, err := net.ResolveTCPAddr("tcp", c.Request.RemoteAddr); err == nil {
+			ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+		}
+
+		// 比如zap日志
+		for _, ctxOption := range ctxOptions {
+			ctx = ctxOption.Apply(ctx)
+		}
+
+		// 处理协议
+		respProto, err := handler.HandleProto(ctx, reqProto)
+		if err != nil {
+			c.Set(GinGrpcErrKey, err)
+			renderHttpError(c, status.Convert(err), codec)
+			return
+		}
+		c.Set(GinGrpcRespKey, respProto)
+
+		// 按 Accept 编码返回结果
+		renderHttpResponse(c, respProto, codec)
+	}
+}
+
+// 客户端要protobuf时返回 google.rpc.Status，否则返回json，details 和grpc带的一致
+func renderHttpError(c *gin.Context, s *status.Status, codec *jsonCodec) {
+	code := httpStatusFromCode(s.Code())
+	if delay, ok := retryDelay(s); ok && delay >= 0 {
+		c.Header("Retry-After", strconv.FormatInt(int64(math.Ceil(delay.Seconds())), 10))
+	}
+
+	if wantsProtobuf(c) {
+		body, err := proto.Marshal(s.Proto())
+		if err == nil {
+			c.Data(code, MIMEProtobuf, body)
+			return
+		}
+	}
+
+	if codec.envelope != nil {
+		c.JSON(codec.envelope.WrapError(c, code, s))
+		return
+	}
+
+	c.JSON(code, gin.H{"code": s.Code(), "error_desc": s.Code().String(), "message": s.Message(), "details": StatusDetails(s)})
+}
+
+// 错误的 details 按json编码，和grpc带的一致
+func StatusDetails(s *status.Status) []json.RawMessage {
+	details := make([]json.RawMessage, 0, len(s.Proto().GetDetails()))
+	for _, detail := range s.Proto().GetDetails() {
+		body, err := protojson.Marshal(detail)
+		if err != nil {
+			// 没有注册的类型只返回类型名
+			body, _ = json.Marshal(gin.H{"@type": detail.GetTypeUrl()})
+		}
+		details = append(details, body)
+	}
+	return details
+}
+
+// 和 grpc-gateway 一致的映射
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // 客户端关闭了请求
+	case codes.Unknown:
+		return http.StatusInternalServerError
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.Aborted:
+		return http.StatusConflict
+	case codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Internal:
+		return http.StatusInternalServerError
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DataLoss:
+		return http.StatusInternalServerError
+	}
+	return http.StatusInternalServerError
+}
+
+// 错误带了 RetryInfo 时客户端需要等待的时间
+func retryDelay(s *status.Status) (time.Duration, bool) {
+	for _, detail := range s.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok && info.GetRetryDelay() != nil {
+			return info.GetRetryDelay().AsDuration(), true
+		}
+	}
+	return 0, false
+}
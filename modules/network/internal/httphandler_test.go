@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"context"
+	gingrpc "github.com/dan-and-dna/gin-grpc"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试用的http路由，路径为 /{pkg}/{service}/{method}
+func newTestRouter(t *testing.T, network *Network, middlewares ...gin.HandlerFunc) *gin.Engine {
+	resolver, err := GetPathResolver("path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	network.ginGrpcOption.pathToServiceName = resolver
+
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.Use(middlewares...)
+	router.POST("/:pkg/:service/:method", network.HttpHandler())
+	return router
+}
+
+func TestHttpHandlerContextKeys(t *testing.T) {
+	network := newTestNetwork()
+	err := network.HandleProto("grpc.health.v1", "Health", "Check", nil, gingrpc.Handler{
+		Proto: &grpc_health_v1.HealthCheckRequest{},
+		HandleProto: func(ctx context.Context, req interface{}) (interface{}, error) {
+			if req.(*grpc_health_v1.HealthCheckRequest).Service == "" {
+				return nil, status.Error(codes.InvalidArgument, "empty")
+			}
+			return &grpc_health_v1.HealthCheckResponse{}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	router := newTestRouter(t, network, func(c *gin.Context) {
+		c.Next()
+		keys = keys[:0]
+		for _, key := range []string{GinGrpcReqKey, GinGrpcHandlerKey, GinGrpcRespKey, GinGrpcErrKey} {
+			if _, ok := c.Get(key); ok {
+				keys = append(keys, key)
+			}
+		}
+	})
+
+	do := func(body string) {
+		r := httptest.NewRequest(http.MethodPost, "/grpc.health.v1/Health/Check", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	do(`{"service":"a"}`)
+	if strings.Join(keys, ",") != "gin_grpc_req,gin_grpc_handler,gin_grpc_resp" {
+		t.Fatalf("keys after success: %v", keys)
+	}
+
+	do(`{}`)
+	if strings.Join(keys, ",") != "gin_grpc_req,gin_grpc_handler,gin_grpc_err" {
+		t.Fatalf("keys after error: %v", keys)
+	}
+}
+
+func TestHttpStatusFromCode(t *testing.T) {
+	cases := []struct {
+		code codes.Code
+		want int
+	}{
+		{codes.OK, http.StatusOK},
+		{codes.Canceled, 499},
+		{codes.Unknown, http.StatusInternalServerError},
+		{codes.InvalidArgument, http.StatusBadRequest},
+		{codes.DeadlineExceeded, http.StatusGatewayTimeout},
+		{codes.NotFound, http.StatusNotFound},
+		{codes.AlreadyExists, http.StatusConflict},
+		{codes.PermissionDenied, http.StatusForbidden},
+		{codes.Unauthenticated, http.StatusUnauthorized},
+		{codes.ResourceExhausted, http.StatusTooManyRequests},
+		{codes.FailedPrecondition, http.StatusBadRequest},
+		{codes.Aborted, http.StatusConflict},
+		{codes.OutOfRange, http.StatusBadRequest},
+		{codes.Unimplemented, http.StatusNotImplemented},
+		{codes.Internal, http.StatusInternalServerError},
+		{codes.Unavailable, http.StatusServiceUnavailable},
+		{codes.DataLoss, http.StatusInternalServerError},
+		{codes.Code(100), http.StatusInternalServerError},
+	}
+
+	for _, c := range cases {
+		if got := httpStatusFromCode(c.code); got != c.want {
+			t.Errorf("%s: got %d, want %d", c.code, got, c.want)
+		}
+	}
+}
+
+func TestHttpHandlerErrors(t *testing.T) {
+	network := newTestNetwork()
+	var fail error
+	err := network.HandleProto("grpc.health.v1", "Health", "Check", nil, gingrpc.Handler{
+		Proto: &grpc_health_v1.HealthCheckRequest{},
+		HandleProto: func(context.Context, interface{}) (interface{}, error) {
+			return nil, fail
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newTestRouter(t, network)
+
+	withDetails := func(code codes.Code, details ...protoiface.MessageV1) error {
+		s, err := status.New(code, "failed").WithDetails(details...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.Err()
+	}
+
+	cases := []struct {
+		name       string
+		path       string
+		err        error
+		status     int
+		retryAfter string
+		body       string
+	}{
+		{"unknown method", "/grpc.health.v1/Health/Nope", nil, http.StatusNotFound, "", `"error_desc":"NotFound"`},
+		{"plain error", "/grpc.health.v1/Health/Check", status.Error(codes.PermissionDenied, "denied"), http.StatusForbidden, "", `"details":[]`},
+		{
+			"details", "/grpc.health.v1/Health/Check",
+			withDetails(codes.InvalidArgument, &errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: "service", Description: "empty"}}}),
+			http.StatusBadRequest, "", `"fieldViolations":[{"field":"service","description":"empty"}]`,
+		},
+		{
+			"retry after", "/grpc.health.v1/Health/Check",
+			withDetails(codes.Unavailable, &errdetails.RetryInfo{RetryDelay: durationpb.New(1500 * time.Millisecond)}),
+			http.StatusServiceUnavailable, "2", `"retryDelay":"1.500s"`,
+		},
+	}
+
+	for _, c := range cases {
+		fail = c.err
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(`{}`))
+		r.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, r)
+
+		if w.Code != c.status {
+			t.Errorf("%s: status = %d, want %d", c.name, w.Code, c.status)
+		}
+		if got := w.Header().Get("Retry-After"); got != c.retryAfter {
+			t.Errorf("%s: Retry-After = %q, want %q", c.name, got, c.retryAfter)
+		}
+		if !strings.Contains(w.Body.String(), c.body) {
+			t.Errorf("%s: body = %s, want %s", c.name, w.Body.String(), c.body)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"github.com/dan-and-dna/gin-grpc-network/utils"
+	"github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"reflect"
+)
+
+// 在进程内调用处理者，经过和grpc请求一样的中间件，但不经过序列化和网络
+func (network *Network) Invoke(ctx context.Context, pkg, service, method string, req interface{}) (interface{}, error) {
+	return network.invoke(ctx, utils.MakeFullMethod(pkg, service, method), req)
+}
+
+func (network *Network) invoke(ctx context.Context, fullMethod string, req interface{}) (interface{}, error) {
+	handler, ok := network.ginGrpcOption.GetHandler(fullMethod)
+	if !ok || handler == nil || handler.HandleProto == nil {
+		return nil, status.Errorf(codes.NotFound, "%s: no service can help you", fullMethod)
+	}
+
+	if handler.Proto != nil && reflect.TypeOf(req) != reflect.TypeOf(handler.Proto) {
+		return nil, &TypeMismatchError{Key: fullMethod, Want: fmt.Sprintf("%T", handler.Proto), Got: fmt.Sprintf("%T", req)}
+	}
+
+	// 调用者带的metadata就是被调用者收到的metadata
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		ctx = metadata.NewIncomingContext(ctx, md)
+	}
+
+	return network.callUnary(ctx, fullMethod, req, handler.HandleProto)
+}
+
+// 经过grpc的全局中间件调用处理者
+func (network *Network) callUnary(ctx context.Context, fullMethod string, req interface{}, handler grpc.UnaryHandler) (interface{}, error) {
+	chain := network.unaryChain.Load()
+	if chain == nil {
+		return handler(ctx, req)
+	}
+
+	return (*chain)(ctx, req, &grpc.UnaryServerInfo{FullMethod: fullMethod}, handler)
+}
+
+// 保存grpc的全局中间件，进程内调用时使用
+func (network *Network) setUnaryChain(middlewares []grpc.UnaryServerInterceptor) {
+	if len(middlewares) == 0 {
+		network.unaryChain.Store(nil)
+		return
+	}
+
+	chain := grpc_middleware.ChainUnaryServer(append([]grpc.UnaryServerInterceptor(nil), middlewares...)...)
+	network.unaryChain.Store(&chain)
+}
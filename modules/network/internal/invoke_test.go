@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	gingrpc "github.com/dan-and-dna/gin-grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+	"testing"
+)
+
+func TestInvokeMiddlewares(t *testing.T) {
+	network := newTestNetwork()
+
+	var calls []string
+	record := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			calls = append(calls, name+" "+info.FullMethod)
+			return handler(ctx, req)
+		}
+	}
+	network.setUnaryChain([]grpc.UnaryServerInterceptor{record("global")})
+
+	err := network.HandleProto("grpc.health.v1", "Health", "Check", nil, gingrpc.Handler{
+		Proto: &grpc_health_v1.HealthCheckRequest{},
+		HandleProto: func(ctx context.Context, req interface{}) (interface{}, error) {
+			md, _ := metadata.FromIncomingContext(ctx)
+			calls = append(calls, "handler "+strings.Join(md.Get("x-user"), ","))
+			return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+		},
+	}, record("route"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 调用者带的metadata作为被调用者收到的metadata
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-user", "alice")
+	resp, err := network.Invoke(ctx, "grpc.health.v1", "Health", "Check", &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.(*grpc_health_v1.HealthCheckResponse).Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("resp = %v", resp)
+	}
+
+	want := "global /grpc.health.v1.Health/Check;route /grpc.health.v1.Health/Check;handler alice"
+	if got := strings.Join(calls, ";"); got != want {
+		t.Fatalf("calls = %s, want %s", got, want)
+	}
+}
+
+func TestInvokeRejects(t *testing.T) {
+	network := newTestNetwork()
+
+	called := false
+	network.setUnaryChain([]grpc.UnaryServerInterceptor{func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		called = true
+		return handler(ctx, req)
+	}})
+	if err := network.HandleProto("grpc.health.v1", "Health", "Check", nil, servingHandler()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 请求的类型不对，不经过中间件和处理者
+	_, err := network.Invoke(context.Background(), "grpc.health.v1", "Health", "Check", &grpc_health_v1.HealthCheckResponse{})
+	var mismatch *TypeMismatchError
+	if !errors.As(err, &mismatch) || status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("err = %v, want TypeMismatchError", err)
+	}
+	if called {
+		t.Fatal("middleware ran for a mismatched request")
+	}
+
+	_, err = network.Invoke(context.Background(), "grpc.health.v1", "Health", "Nope", &grpc_health_v1.HealthCheckRequest{})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("err = %v, want NotFound", err)
+	}
+}
@@ -0,0 +1,272 @@
+package internal
+
+import (
+	"context"
+	"github.com/dan-and-dna/gin-grpc-network/utils"
+	"github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 中间件快照，发布后只读
+type routeMiddlewaresSnapshot struct {
+	services       map[string][]grpc.UnaryServerInterceptor
+	servicesStream map[string][]grpc.StreamServerInterceptor
+	methods        map[string][]grpc.UnaryServerInterceptor
+	methodsStream  map[string][]grpc.StreamServerInterceptor
+}
+
+// 挂在某个服务或者某个方法上的中间件，http和grpc共用，查询不加锁
+type RouteMiddlewares struct {
+	rawServices       map[string][]grpc.UnaryServerInterceptor  // 按注册时的服务名保存
+	rawServicesStream map[string][]grpc.StreamServerInterceptor // 按注册时的服务名保存
+	methods           map[string][]grpc.UnaryServerInterceptor
+	methodsStream     map[string][]grpc.StreamServerInterceptor
+	caseSensitive     bool
+	snapshot          atomic.Pointer[routeMiddlewaresSnapshot]
+	mu                sync.Mutex
+}
+
+func (mws *RouteMiddlewares) UseService(serviceKey string, middlewares ...grpc.UnaryServerInterceptor) {
+	mws.mu.Lock()
+	defer mws.mu.Unlock()
+
+	if mws.rawServices == nil {
+		mws.rawServices = make(map[string][]grpc.UnaryServerInterceptor)
+	}
+	mws.rawServices[serviceKey] = append(mws.rawServices[serviceKey], middlewares...)
+	mws.publish()
+}
+
+func (mws *RouteMiddlewares) UseServiceStream(serviceKey string, middlewares ...grpc.StreamServerInterceptor) {
+	mws.mu.Lock()
+	defer mws.mu.Unlock()
+
+	if mws.rawServicesStream == nil {
+		mws.rawServicesStream = make(map[string][]grpc.StreamServerInterceptor)
+	}
+	mws.rawServicesStream[serviceKey] = append(mws.rawServicesStream[serviceKey], middlewares...)
+	mws.publish()
+}
+
+// 方法的中间件由注册时重新设置，这里只重建服务的中间件
+func (mws *RouteMiddlewares) SetCaseSensitive(caseSensitive bool) {
+	mws.mu.Lock()
+	defer mws.mu.Unlock()
+
+	mws.caseSensitive = caseSensitive
+	mws.publish()
+}
+
+// 方法的中间件随注册替换
+func (mws *RouteMiddlewares) SetMethod(key string, middlewares []grpc.UnaryServerInterceptor) {
+	mws.mu.Lock()
+	defer mws.mu.Unlock()
+
+	if mws.methods == nil {
+		mws.methods = make(map[string][]grpc.UnaryServerInterceptor)
+	}
+
+	if len(middlewares) == 0 {
+		delete(mws.methods, key)
+	} else {
+		mws.methods[key] = middlewares
+	}
+	mws.publish()
+}
+
+func (mws *RouteMiddlewares) SetMethodStream(key string, middlewares []grpc.StreamServerInterceptor) {
+	mws.mu.Lock()
+	defer mws.mu.Unlock()
+
+	if mws.methodsStream == nil {
+		mws.methodsStream = make(map[string][]grpc.StreamServerInterceptor)
+	}
+
+	if len(middlewares) == 0 {
+		delete(mws.methodsStream, key)
+	} else {
+		mws.methodsStream[key] = middlewares
+	}
+	mws.publish()
+}
+
+// 服务的中间件在前，方法的中间件在后
+func (mws *RouteMiddlewares) Unary(key string) []grpc.UnaryServerInterceptor {
+	return mws.snapshot.Load().unary(key)
+}
+
+func (mws *RouteMiddlewares) Stream(key string) []grpc.StreamServerInterceptor {
+	return mws.snapshot.Load().stream(key)
+}
+
+func (snapshot *routeMiddlewaresSnapshot) unary(key string) []grpc.UnaryServerInterceptor {
+	if snapshot == nil {
+		return nil
+	}
+
+	serviceMws := snapshot.services[serviceKeyOf(key)]
+	methodMws := snapshot.methods[key]
+	if len(serviceMws) == 0 {
+		return methodMws
+	}
+
+	chain := make([]grpc.UnaryServerInterceptor, 0, len(serviceMws)+len(methodMws))
+	chain = append(chain, serviceMws...)
+	return append(chain, methodMws...)
+}
+
+func (snapshot *routeMiddlewaresSnapshot) stream(key string) []grpc.StreamServerInterceptor {
+	if snapshot == nil {
+		return nil
+	}
+
+	serviceMws := snapshot.servicesStream[serviceKeyOf(key)]
+	methodMws := snapshot.methodsStream[key]
+	if len(serviceMws) == 0 {
+		return methodMws
+	}
+
+	chain := make([]grpc.StreamServerInterceptor, 0, len(serviceMws)+len(methodMws))
+	chain = append(chain, serviceMws...)
+	return append(chain, methodMws...)
+}
+
+// 复制一份新的快照，不区分大小写时只差大小写的服务共用中间件
+func (mws *RouteMiddlewares) publish() {
+	snapshot := &routeMiddlewaresSnapshot{
+		services:       make(map[string][]grpc.UnaryServerInterceptor, len(mws.rawServices)),
+		servicesStream: make(map[string][]grpc.StreamServerInterceptor, len(mws.rawServicesStream)),
+		methods:        make(map[string][]grpc.UnaryServerInterceptor, len(mws.methods)),
+		methodsStream:  make(map[string][]grpc.StreamServerInterceptor, len(mws.methodsStream)),
+	}
+
+	for serviceKey, middlewares := range mws.rawServices {
+		serviceKey = utils.NormalizeKey(serviceKey, mws.caseSensitive)
+		snapshot.services[serviceKey] = append(snapshot.services[serviceKey], middlewares...)
+	}
+
+	for serviceKey, middlewares := range mws.rawServicesStream {
+		serviceKey = utils.NormalizeKey(serviceKey, mws.caseSensitive)
+		snapshot.servicesStream[serviceKey] = append(snapshot.servicesStream[serviceKey], middlewares...)
+	}
+
+	for key, middlewares := range mws.methods {
+		snapshot.methods[key] = middlewares
+	}
+
+	for key, middlewares := range mws.methodsStream {
+		snapshot.methodsStream[key] = middlewares
+	}
+
+	mws.snapshot.Store(snapshot)
+}
+
+// 某个快照下合成的中间件，没有中间件时 chain 为空
+type builtChain[T any] struct {
+	snapshot *routeMiddlewaresSnapshot
+	chain    *T
+}
+
+// 每个处理者各自缓存合成的中间件，注册时合成一次，中间件变化后的第一次调用重新合成
+type chainCache[T any] struct {
+	mws   *RouteMiddlewares
+	built atomic.Pointer[builtChain[T]]
+	build func(*routeMiddlewaresSnapshot) *T
+}
+
+func newChainCache[T any](mws *RouteMiddlewares, build func(*routeMiddlewaresSnapshot) *T) *chainCache[T] {
+	cache := &chainCache[T]{mws: mws, build: build}
+	cache.get()
+	return cache
+}
+
+func (cache *chainCache[T]) get() *T {
+	snapshot := cache.mws.snapshot.Load()
+	if built := cache.built.Load(); built != nil && built.snapshot == snapshot {
+		return built.chain
+	}
+
+	built := &builtChain[T]{snapshot: snapshot, chain: cache.build(snapshot)}
+	cache.built.Store(built)
+	return built.chain
+}
+
+// 包装处理者，调用时带上路由的中间件
+func (network *Network) wrapHandler(key, fullMethod string, handler func(context.Context, interface{}) (interface{}, error)) func(context.Context, interface{}) (interface{}, error) {
+	info := &grpc.UnaryServerInfo{FullMethod: fullMethod}
+	cache := newChainCache(network.routeMiddlewares, func(snapshot *routeMiddlewaresSnapshot) *grpc.UnaryServerInterceptor {
+		middlewares := snapshot.unary(key)
+		if len(middlewares) == 0 {
+			return nil
+		}
+		chain := grpc_middleware.ChainUnaryServer(middlewares...)
+		return &chain
+	})
+
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		chain := cache.get()
+		if chain == nil {
+			return handler(ctx, req)
+		}
+
+		return (*chain)(ctx, req, info, handler)
+	}
+}
+
+// 包装监听者，调用时带上路由的中间件
+func (network *Network) wrapListener(key string, info *grpc.StreamServerInfo, listener func(grpc.ServerStream) error) func(grpc.ServerStream) error {
+	handler := func(_ interface{}, ss grpc.ServerStream) error {
+		return listener(ss)
+	}
+	cache := newChainCache(network.routeMiddlewares, func(snapshot *routeMiddlewaresSnapshot) *grpc.StreamServerInterceptor {
+		middlewares := snapshot.stream(key)
+		if len(middlewares) == 0 {
+			return nil
+		}
+		chain := grpc_middleware.ChainStreamServer(middlewares...)
+		return &chain
+	})
+
+	return func(ss grpc.ServerStream) error {
+		chain := cache.get()
+		if chain == nil {
+			return listener(ss)
+		}
+
+		return (*chain)(nil, ss, info, handler)
+	}
+}
+
+// 在服务上挂中间件，对服务下所有方法生效
+func (network *Network) UseService(pkg, service string, middlewares ...grpc.UnaryServerInterceptor) {
+	network.routeMiddlewares.UseService(network.canonicalServiceKey(pkg, service), middlewares...)
+}
+
+func (network *Network) UseServiceStream(pkg, service string, middlewares ...grpc.StreamServerInterceptor) {
+	network.routeMiddlewares.UseServiceStream(network.canonicalServiceKey(pkg, service), middlewares...)
+}
+
+// /pkg.service/method => /pkg.service/
+func serviceKeyOf(key string) string {
+	return key[:strings.LastIndexByte(key, '/')+1]
+}
+
+// 从服务描述中找出流的类型
+func streamInfo(desc *grpc.ServiceDesc, fullMethod, method string) *grpc.StreamServerInfo {
+	info := &grpc.StreamServerInfo{FullMethod: fullMethod, IsServerStream: true}
+	if desc == nil {
+		return info
+	}
+
+	for _, stream := range desc.Streams {
+		if strings.EqualFold(stream.StreamName, method) {
+			info.IsClientStream = stream.ClientStreams
+			info.IsServerStream = stream.ServerStreams
+			break
+		}
+	}
+	return info
+}
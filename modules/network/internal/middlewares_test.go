@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"testing"
+)
+
+func TestChainCacheRebuildsOnChange(t *testing.T) {
+	mws := new(RouteMiddlewares)
+	mws.SetMethod("/pkg.service/method", []grpc.UnaryServerInterceptor{passThrough})
+
+	builds := 0
+	cache := newChainCache(mws, func(snapshot *routeMiddlewaresSnapshot) *int {
+		builds++
+		n := len(snapshot.unary("/pkg.service/method"))
+		return &n
+	})
+
+	// 注册时合成一次，中间件不变时不再合成
+	for i := 0; i < 3; i++ {
+		if n := *cache.get(); n != 1 {
+			t.Fatalf("middlewares = %d, want 1", n)
+		}
+	}
+	if builds != 1 {
+		t.Fatalf("builds = %d, want 1", builds)
+	}
+
+	mws.UseService("/pkg.service/", passThrough)
+	if n := *cache.get(); n != 2 {
+		t.Fatalf("middlewares = %d, want 2", n)
+	}
+	if builds != 2 {
+		t.Fatalf("builds = %d, want 2", builds)
+	}
+}
+
+func TestWrapHandlerSeesLaterServiceMiddlewares(t *testing.T) {
+	network := newTestNetwork()
+	if err := network.HandleProto("pkg", "Service", "Method", nil, testHandler()); err != nil {
+		t.Fatal(err)
+	}
+
+	called := 0
+	network.UseService("pkg", "Service", func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		called++
+		return handler(ctx, req)
+	})
+
+	handler, _ := network.grpcRouteOption.GetHandler("/pkg.Service/Method")
+	handler(context.Background(), nil)
+	handler(context.Background(), nil)
+	if called != 2 {
+		t.Fatalf("service middleware called %d times, want 2", called)
+	}
+}
+
+func passThrough(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(ctx, req)
+}
@@ -0,0 +1,267 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/dan-and-dna/gin-grpc-network/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"log"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 默认的镜像设置
+const (
+	defaultMirrorTimeout  = time.Second
+	defaultMirrorInflight = 64
+)
+
+// 影子和主处理者的结果不一致
+type MirrorDiff struct {
+	Key        string      // grpc的完整方法名
+	Req        interface{} // 请求的副本
+	Resp       interface{} // 主处理者的回复
+	Err        error       // 主处理者的错误
+	ShadowResp interface{} // 影子的回复
+	ShadowErr  error       // 影子的错误
+}
+
+type MirrorOption struct {
+	Handler     func(context.Context, interface{}) (interface{}, error) // 影子处理者，和 Target 二选一
+	Target      string                                                  // 影子grpc服务的地址
+	DialOptions []grpc.DialOption                                       // 连接影子grpc服务的设置，为空时不加密
+	Percent     int                                                     // 镜像的比例(1-100)，0表示全部
+	Timeout     time.Duration                                           // 影子的处理超时，0为1秒
+	MaxInflight int                                                     // 同时进行的镜像数，超过时丢弃，0为64
+	OnDiff      func(MirrorDiff)                                        // 结果不一致时调用，为空时打日志
+}
+
+// 一个方法的镜像
+type mirror struct {
+	option   MirrorOption
+	conn     *grpc.ClientConn
+	release  func()
+	inflight chan struct{}
+	counter  atomic.Uint64
+
+	mu     sync.Mutex
+	calls  int  // 进行中的影子调用
+	closed bool // 镜像已经被替换或者去掉
+}
+
+// 影子调用开始前登记，镜像已经关闭时不再调用
+func (m *mirror) begin() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return false
+	}
+	m.calls++
+	return true
+}
+
+func (m *mirror) end() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls--
+	if m.closed && m.calls == 0 && m.release != nil {
+		m.release()
+	}
+}
+
+// 关闭镜像，进行中的影子调用都结束后再释放连接
+func (m *mirror) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	if m.calls == 0 && m.release != nil {
+		m.release()
+	}
+}
+
+// 按比例采样，不需要随机
+func (m *mirror) sample() bool {
+	if m.option.Percent <= 0 || m.option.Percent >= 100 {
+		return true
+	}
+
+	return m.counter.Add(1)%100 < uint64(m.option.Percent)
+}
+
+// 给方法加上镜像，请求的副本异步交给影子，影子的回复只用来和主回复比较
+func (network *Network) Mirror(pkg, service, method string, option MirrorOption) error {
+	fullMethod := utils.MakeFullMethod(pkg, service, method)
+	if (option.Handler == nil) == (option.Target == "") {
+		return fmt.Errorf("mirror %s: need exactly one of handler and target", fullMethod)
+	}
+
+	if option.Timeout <= 0 {
+		option.Timeout = defaultMirrorTimeout
+	}
+	if option.MaxInflight <= 0 {
+		option.MaxInflight = defaultMirrorInflight
+	}
+
+	m := &mirror{option: option, inflight: make(chan struct{}, option.MaxInflight)}
+	if option.Target != "" {
+		conn, release, err := network.remoteConns.acquire(option.Target, option.DialOptions)
+		if err != nil {
+			return err
+		}
+		m.conn, m.release = conn, release
+	}
+
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	key := network.methodFullName(pkg, service, method)
+	if old, ok := network.mirrors.Get(key); ok {
+		old.close()
+	}
+	network.mirrors.Set(key, m)
+	return nil
+}
+
+// 去掉方法的镜像
+func (network *Network) StopMirror(pkg, service, method string) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	key := network.methodFullName(pkg, service, method)
+	if m, ok := network.mirrors.Get(key); ok {
+		network.mirrors.Remove(key)
+		m.close()
+	}
+}
+
+// 包装处理者，主处理者处理完后把请求的副本交给影子
+func (network *Network) mirrorHandler(fullMethod string, handler func(context.Context, interface{}) (interface{}, error)) func(context.Context, interface{}) (interface{}, error) {
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		m, ok := network.mirrors.Get(fullMethod)
+		if !ok || !m.sample() {
+			return handler(ctx, req)
+		}
+
+		// 处理者可能修改请求，先复制一份
+		shadowReq := cloneMessage(req)
+		resp, err := handler(ctx, req)
+
+		select {
+		case m.inflight <- struct{}{}:
+			if !m.begin() {
+				<-m.inflight
+				break
+			}
+			md, _ := metadata.FromIncomingContext(ctx)
+			primaryResp := cloneMessage(resp)
+			options := network.compressOptions()
+			go func() {
+				defer func() { <-m.inflight }()
+				defer m.end()
+				m.replay(metadata.NewIncomingContext(context.Background(), md.Copy()), fullMethod, shadowReq, primaryResp, err, options)
+			}()
+		default:
+			// 影子太慢，丢弃，不影响主处理者
+		}
+
+		return resp, err
+	}
+}
+
+// 把请求交给影子并比较结果
+func (m *mirror) replay(ctx context.Context, fullMethod string, req, resp interface{}, err error, options []grpc.CallOption) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[network] mirror of %s panicked: %v\n", fullMethod, r)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, m.option.Timeout)
+	defer cancel()
+
+	var shadowResp interface{}
+	var shadowErr error
+	if m.option.Handler != nil {
+		shadowResp, shadowErr = m.option.Handler(ctx, req)
+	} else {
+		shadowResp, shadowErr = m.invoke(ctx, fullMethod, req, resp, options)
+	}
+
+	if sameResult(resp, err, shadowResp, shadowErr) {
+		return
+	}
+
+	diff := MirrorDiff{Key: fullMethod, Req: req, Resp: resp, Err: err, ShadowResp: shadowResp, ShadowErr: shadowErr}
+	if m.option.OnDiff != nil {
+		m.option.OnDiff(diff)
+		return
+	}
+	// 回复可能有敏感数据，日志只记录大小和哈希
+	log.Printf("[network] mirror diff %s: resp=%s code=%s shadow_resp=%s shadow_code=%s\n", fullMethod, digest(resp), status.Code(err), digest(shadowResp), status.Code(shadowErr))
+}
+
+// 回复的大小和哈希
+func digest(v interface{}) string {
+	var data []byte
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case *rawFrame:
+		data = v.payload
+	case proto.Message:
+		var err error
+		if data, err = (proto.MarshalOptions{Deterministic: true}).Marshal(v); err != nil {
+			return fmt.Sprintf("%T", v)
+		}
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("size=%d sha256=%x", len(data), sum[:8])
+}
+
+// 调用影子grpc服务，回复按主回复的类型解码，主处理者出错时只比较错误码
+func (m *mirror) invoke(ctx context.Context, fullMethod string, req, resp interface{}, options []grpc.CallOption) (interface{}, error) {
+	ctx = remoteContext(ctx)
+	if message, ok := resp.(proto.Message); ok && message != nil {
+		shadowResp := message.ProtoReflect().New().Interface()
+		if err := m.conn.Invoke(ctx, fullMethod, req, shadowResp, options...); err != nil {
+			return nil, err
+		}
+		return shadowResp, nil
+	}
+
+	shadowResp := new(rawFrame)
+	if err := m.conn.Invoke(ctx, fullMethod, req, shadowResp, append(options, grpc.ForceCodec(newFrameCodec()))...); err != nil {
+		return nil, err
+	}
+	return shadowResp, nil
+}
+
+// 错误码一致，成功时回复也一致
+func sameResult(resp interface{}, err error, shadowResp interface{}, shadowErr error) bool {
+	if status.Code(err) != status.Code(shadowErr) {
+		return false
+	}
+
+	if err != nil {
+		return true
+	}
+
+	a, ok := resp.(proto.Message)
+	b, shadowOk := shadowResp.(proto.Message)
+	if ok && shadowOk {
+		return proto.Equal(a, b)
+	}
+	return reflect.DeepEqual(resp, shadowResp)
+}
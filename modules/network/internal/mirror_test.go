@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"context"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMirrorReplaceWaitsForShadowCalls(t *testing.T) {
+	network := newTestNetwork()
+	if err := network.HandleProto("pkg", "Service", "Method", nil, testHandler()); err != nil {
+		t.Fatal(err)
+	}
+
+	started, unblock := make(chan struct{}), make(chan struct{})
+	shadow := func(context.Context, interface{}) (interface{}, error) {
+		close(started)
+		<-unblock
+		return nil, nil
+	}
+	if err := network.Mirror("pkg", "Service", "Method", MirrorOption{Handler: shadow}); err != nil {
+		t.Fatal(err)
+	}
+
+	old, _ := network.mirrors.Get("/pkg.Service/Method")
+	released := make(chan struct{})
+	old.release = func() { close(released) }
+
+	handler, _ := network.grpcRouteOption.GetHandler("/pkg.Service/Method")
+	if _, err := handler(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	// 替换镜像时影子调用还没结束，连接不能释放
+	if err := network.Mirror("pkg", "Service", "Method", MirrorOption{Handler: shadow}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-released:
+		t.Fatal("released while a shadow call is in flight")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(unblock)
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("not released after the shadow call finished")
+	}
+
+	if old.begin() {
+		t.Fatal("closed mirror accepted a new shadow call")
+	}
+}
+
+func TestMirrorDigest(t *testing.T) {
+	got := digest(&rawFrame{payload: []byte("secret")})
+	if strings.Contains(got, "secret") || !strings.HasPrefix(got, "size=6 sha256=") {
+		t.Fatalf("digest = %q", got)
+	}
+}
+
+func TestMirrorUsesRegisteredFullMethod(t *testing.T) {
+	network := newTestNetwork()
+	network.core.CaseSensitive = true
+	if err := network.Recreate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.HandleProto("grpc.health.v1", "health", "check", &grpc_health_v1.Health_ServiceDesc, testHandler()); err != nil {
+		t.Fatal(err)
+	}
+
+	shadowed := make(chan struct{}, 1)
+	shadow := func(context.Context, interface{}) (interface{}, error) {
+		shadowed <- struct{}{}
+		return nil, nil
+	}
+	if err := network.Mirror("grpc.health.v1", "health", "check", MirrorOption{Handler: shadow}); err != nil {
+		t.Fatal(err)
+	}
+
+	handler, _ := network.grpcRouteOption.GetHandler("/grpc.health.v1.Health/Check")
+	handler(context.Background(), nil)
+	select {
+	case <-shadowed:
+	case <-time.After(time.Second):
+		t.Fatal("mirror set with the registered names was ignored")
+	}
+
+	network.StopMirror("grpc.health.v1", "health", "check")
+	if _, ok := network.mirrors.Get("/grpc.health.v1.Health/Check"); ok {
+		t.Fatal("mirror kept after StopMirror")
+	}
+}
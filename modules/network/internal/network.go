@@ -38,14 +38,31 @@ type Network struct {
 	httpRouter            *gin.Engine
 	grpcSrv               *grpc.Server
 	grpcListener          net.Listener
-	listeners             map[string][]func(context.Context, interface{})                    // 协议监听者
-	handlers              map[string]func(context.Context, interface{}) (interface{}, error) // 协议处理者
-	ginGrpcOption         *GinGrpcOption                                                     // GinGrpc 选项
-	grpcRouteOption       *GrpcRouteOption                                                   // GrpcRoute 选项
-	grpcRouteOptionStream *GrpcRouteOptionStream                                             // GrpcRouteStream 选项
-	grpcServiceDescMap    map[string]*grpc.ServiceDesc                                       // grpc 服务
-	isRunning             bool                                                               // 是否正在运行
-	coreChanged           atomic.Bool                                                        // 配置是否更新
+	bus                   *EventBus                                   // 协议的订阅者
+	ginGrpcOption         *GinGrpcOption                              // GinGrpc 选项
+	grpcRouteOption       *GrpcRouteOption                            // GrpcRoute 选项
+	grpcRouteOptionStream *GrpcRouteOptionStream                      // GrpcRouteStream 选项
+	grpcServiceDescMap    map[string]*grpc.ServiceDesc                // grpc 服务
+	registrations         map[string]*registration                    // 所有的注册，key为grpc的完整方法名
+	caseSensitive         bool                                        // 路由是否区分大小写
+	registerErrs          []error                                     // 注册失败的记录，启动时检查后清空
+	started               bool                                        // 是否启动过
+	listenHttp            bool                                        // 当前是否开启http，路由表使用
+	listenGrpc            bool                                        // 当前是否开启grpc，路由表使用
+	routeMiddlewares      *RouteMiddlewares                           // 路由的中间件
+	timeouts              atomic.Pointer[Timeouts]                    // 处理超时
+	unaryChain            atomic.Pointer[grpc.UnaryServerInterceptor] // grpc的全局中间件，进程内调用使用
+	proxy                 atomic.Pointer[Proxy]                       // 没有处理者的方法转发给上游
+	remoteConns           *RemoteConns                                // 远程grpc服务的连接
+	remoteServices        map[string]string                           // 按配置转发的服务
+	remoteDialOptions     []grpc.DialOption                           // 按配置转发的服务的连接设置
+	mirrors               RouteTable[*mirror]                         // 方法的镜像
+	canaries              RouteTable[*canary]                         // 方法的版本和灰度规则
+	jsonCodec             atomic.Pointer[jsonCodec]                   // http的json编码设置
+	compression           atomic.Pointer[compression]                 // http回复的压缩设置
+	grpcCompressor        atomic.Value                                // 调用上游grpc时的压缩算法
+	isRunning             bool                                        // 是否正在运行
+	coreChanged           atomic.Bool                                 // 配置是否更新
 	mu                    sync.Mutex
 }
 
@@ -53,11 +70,16 @@ func (network *Network) ModuleConstruct() {
 	// 加载配置文件
 	network.core = new(core.NetworkCore)
 
-	network.listeners = make(map[string][]func(context.Context, interface{}))
+	network.bus = new(EventBus)
 	network.grpcServiceDescMap = make(map[string]*grpc.ServiceDesc)
+	network.registrations = make(map[string]*registration)
 	network.ginGrpcOption = new(GinGrpcOption)
 	network.grpcRouteOption = new(GrpcRouteOption)
 	network.grpcRouteOptionStream = new(GrpcRouteOptionStream)
+	network.routeMiddlewares = new(RouteMiddlewares)
+	network.remoteConns = new(RemoteConns)
+	network.remoteServices = make(map[string]string)
+	network.jsonCodec.Store(newJsonCodec(nil, nil))
 	network.core.Lock()
 	network.core.Enable = true
 	network.core.Unlock()
@@ -87,7 +109,10 @@ func (network *Network) ModuleRestart() bool {
 	if network.coreChanged.CompareAndSwap(true, false) {
 		log.Println("[network] start restart")
 		network.Stop()
-		network.Recreate()
+		if err := network.Recreate(); err != nil {
+			log.Println("[network] failed to recreate:", err)
+			return true
+		}
 		network.Start()
 		return true
 	}
@@ -154,7 +179,13 @@ func (network *Network) Start() {
 		}
 
 		path := network.core.HttpPath
-		network.httpRouter.POST(path, gingrpc.GinGrpc(network.ginGrpcOption, true, network.core.HttpCtxOptions...))
+		network.httpRouter.POST(path, network.HttpHandler(network.core.HttpCtxOptions...))
+		if adminPath := network.core.HttpAdminPath; adminPath != "" {
+			// 鉴权等中间件在查看路由表之前执行
+			handlers := make([]gin.HandlerFunc, 0, len(network.core.HttpAdminMiddlewares)+1)
+			handlers = append(handlers, network.core.HttpAdminMiddlewares...)
+			network.httpRouter.GET(adminPath, append(handlers, network.ServeRoutes)...)
+		}
 
 		go func() {
 			if err := network.httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -174,6 +205,11 @@ func (network *Network) Stop() {
 		network.grpcListener.Close()
 	}
 
+	// 反向代理的连接
+	if proxy := network.proxy.Swap(nil); proxy != nil {
+		proxy.Close()
+	}
+
 	// http 服务
 	if network.httpSrv != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -199,6 +235,37 @@ func (network *Network) Recreate() error {
 	if !network.core.Enable {
 		return nil
 	}
+
+	// 路由模式变化时重建路由表，合并掉的注册也算注册失败
+	network.mu.Lock()
+	network.setCaseSensitive(network.core.CaseSensitive)
+	registerErrs := network.takeRegisterErrs()
+	started := network.started
+	network.started = true
+	network.listenHttp, network.listenGrpc = network.core.ListenHttp, network.core.ListenGrpc
+	network.mu.Unlock()
+
+	// 严格模式：第一次启动前有注册失败直接 panic，重启时返回错误
+	if network.core.StrictRegister && len(registerErrs) > 0 {
+		err := fmt.Errorf("%w: %d register errors: %v", ErrBadRegister, len(registerErrs), registerErrs)
+		if !started {
+			panic("[network] " + err.Error())
+		}
+		return err
+	}
+	if !network.core.CaseSensitive {
+		for _, collision := range network.CaseCollisions() {
+			log.Println("[network] 方法名只差大小写，已经合并:", collision)
+		}
+	}
+
+	network.timeouts.Store(NewTimeouts(network.core.CaseSensitive, network.core.HandleTimeOut, network.core.ServiceTimeOuts, network.core.MethodTimeOuts))
+	network.setUnaryChain(network.core.GrpcMiddlewares)
+	network.syncRemoteServices(network.core.RemoteServices, network.core.RemoteDialOptions)
+	network.jsonCodec.Store(newJsonCodec(network.core.HttpJsonOptions, network.core.HttpEnvelope))
+	network.compression.Store(newCompression(network.core.HttpCompression))
+	network.grpcCompressor.Store(network.core.GrpcCompressor)
+
 	// 清理
 	network.httpSrv = nil
 	network.httpRouter = nil
@@ -207,11 +274,20 @@ func (network *Network) Recreate() error {
 
 	// 重建
 	if network.core.ListenHttp {
+		// 没有自定义的解析方式就使用内置的
+		pathToServiceName := network.core.HttpPathToServiceName
+		if pathToServiceName == nil {
+			var err error
+			if pathToServiceName, err = GetPathResolver(network.core.HttpPathResolver); err != nil {
+				return err
+			}
+		}
+
 		gin.SetMode(gin.ReleaseMode)
 		network.httpRouter = gin.New()
 		// http中间件
 		network.httpRouter.Use(network.core.HttpMiddlewares...)
-		network.ginGrpcOption.pathToServiceName = network.core.HttpPathToServiceName
+		network.ginGrpcOption.pathToServiceName = pathToServiceName
 
 		network.httpSrv = &http.Server{
 			Addr:         fmt.Sprintf("%s:%d", network.core.ListenIp, network.core.ListenPort),
@@ -226,17 +302,34 @@ func (network *Network) Recreate() error {
 			return err
 		}
 
-		// grpc中间件
-		network.core.GrpcMiddlewares = append(network.core.GrpcMiddlewares, grpcroute.GrpcRoute(network.grpcRouteOption), network.NoFound)
-		network.core.GrpcMiddlewaresStream = append(network.core.GrpcMiddlewaresStream, grpcroute.GrpcRouteStream(network.grpcRouteOptionStream), network.NoFoundStream)
-		network.grpcSrv = grpc.NewServer(
+		// grpc中间件，路由放在最后，不修改配置里的中间件，重启时不会重复添加
+		middlewares := append(append([]grpc.UnaryServerInterceptor(nil), network.core.GrpcMiddlewares...), network.routeUnary)
+		middlewaresStream := append(append([]grpc.StreamServerInterceptor(nil), network.core.GrpcMiddlewaresStream...), network.routeStream)
+		options := []grpc.ServerOption{
+			// 启动后才注册的服务不用重启也能调用
+			grpc.UnknownServiceHandler(network.unknownService),
 			grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
-				network.core.GrpcMiddlewares...,
+				middlewares...,
 			)),
 			grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
-				network.core.GrpcMiddlewaresStream...,
+				middlewaresStream...,
 			)),
-		)
+		}
+		options = append(options, network.serverCompressOptions()...)
+
+		// 没有处理者的方法原样转发给上游
+		if target := network.core.GrpcProxyTarget; target != "" {
+			proxy, err := NewProxy(target, network.core.GrpcProxyDialOptions, network.compressOptions()...)
+			if err != nil {
+				network.grpcListener.Close()
+				network.grpcListener = nil
+				return err
+			}
+			network.proxy.Store(proxy)
+			options = append(options, grpc.ForceServerCodec(proxy.codec))
+			log.Println("[network] 没有处理者的方法转发给:", target)
+		}
+		network.grpcSrv = grpc.NewServer(options...)
 
 		network.mu.Lock()
 		defer network.mu.Unlock()
@@ -258,36 +351,56 @@ func (network *Network) NoFoundStream(srv interface{}, ss grpc.ServerStream, inf
 	return status.New(codes.NotFound, "no service can help you").Err()
 }
 
-func (network *Network) ListenProto(pkg, service, method string, desc *grpc.ServiceDesc, listener func(grpc.ServerStream) error) {
-	network.grpcRouteOptionStream.SetHandler(utils.MakeKey(pkg, service, method), listener)
-	if desc == nil {
-		return
-	}
-
+func (network *Network) ListenProto(pkg, service, method string, desc *grpc.ServiceDesc, listener func(grpc.ServerStream) error, middlewares ...grpc.StreamServerInterceptor) error {
 	network.mu.Lock()
 	defer network.mu.Unlock()
-	network.grpcServiceDescMap[desc.ServiceName] = desc
+
+	if listener == nil {
+		return network.registerFailed(fmt.Errorf("%w: nil listener for %s", ErrBadRegister, utils.MakeFullMethod(pkg, service, method)))
+	}
+
+	return network.register(&registration{
+		pkg:               pkg,
+		service:           service,
+		method:            method,
+		desc:              desc,
+		listener:          listener,
+		middlewaresStream: middlewares,
+	})
 }
 
 func (network *Network) StopListenProto(pkg, service, method string) {
-	network.grpcRouteOptionStream.RemoveHandler(utils.MakeKey(pkg, service, method))
-}
+	network.mu.Lock()
+	defer network.mu.Unlock()
 
-func (network *Network) HandleProto(pkg, service, method string, desc *grpc.ServiceDesc, handler gingrpc.Handler) {
-	network.ginGrpcOption.SetHandler(utils.MakeKey(pkg, service, method), &handler)
-	network.grpcRouteOption.SetHandler(utils.MakeKey(pkg, service, method), handler.HandleProto)
+	network.unregister(pkg, service, method, true)
+}
 
-	if desc == nil {
-		return
-	}
+func (network *Network) HandleProto(pkg, service, method string, desc *grpc.ServiceDesc, handler gingrpc.Handler, middlewares ...grpc.UnaryServerInterceptor) error {
 	network.mu.Lock()
 	defer network.mu.Unlock()
-	network.grpcServiceDescMap[desc.ServiceName] = desc
+
+	if handler.HandleProto == nil {
+		return network.registerFailed(fmt.Errorf("%w: nil handler for %s", ErrBadRegister, utils.MakeFullMethod(pkg, service, method)))
+	}
+
+	return network.register(&registration{
+		pkg:         pkg,
+		service:     service,
+		method:      method,
+		desc:        desc,
+		handler:     &handler,
+		middlewares: middlewares,
+	})
 }
 
 func (network *Network) StopHandleProto(pkg, service, method string) {
-	network.ginGrpcOption.RemoveGrpcHandler(utils.MakeKey(pkg, service, method))
-	network.grpcRouteOption.RemoveHandler(utils.MakeKey(pkg, service, method))
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	// 方法的版本和灰度规则一起去掉，重新注册时不会沿用
+	network.canaries.Remove(network.methodFullName(pkg, service, method))
+	network.unregister(pkg, service, method, false)
 }
 
 type Bench struct {
@@ -4,25 +4,14 @@ import (
 	gingrpc "github.com/dan-and-dna/gin-grpc"
 	grpcroute "github.com/dan-and-dna/grpc-route"
 	"github.com/gin-gonic/gin"
-	"strings"
-	"sync"
 )
 
 type GrpcRouteOption struct {
-	Handlers map[string]grpcroute.HandleProto
-	sync.RWMutex
+	handlers RouteTable[grpcroute.HandleProto]
 }
 
 func (option *GrpcRouteOption) GetHandler(key string) (grpcroute.HandleProto, bool) {
-	option.RLock()
-	defer option.RUnlock()
-
-	handler, ok := option.Handlers[strings.ToLower(key)]
-	if ok {
-		return handler, true
-	}
-
-	return nil, false
+	return option.handlers.Get(key)
 }
 
 func (option *GrpcRouteOption) SetHandler(key string, h grpcroute.HandleProto) {
@@ -30,38 +19,23 @@ func (option *GrpcRouteOption) SetHandler(key string, h grpcroute.HandleProto) {
 		return
 	}
 
-	option.Lock()
-	defer option.Unlock()
-
-	if option.Handlers == nil {
-		option.Handlers = make(map[string]grpcroute.HandleProto)
-	}
-
-	option.Handlers[key] = h
+	option.handlers.Set(key, h)
 }
 
 func (option *GrpcRouteOption) RemoveHandler(key string) {
-	option.Lock()
-	defer option.Unlock()
+	option.handlers.Remove(key)
+}
 
-	delete(option.Handlers, key)
+func (option *GrpcRouteOption) SetCaseSensitive(caseSensitive bool) {
+	option.handlers.SetCaseSensitive(caseSensitive)
 }
 
 type GrpcRouteOptionStream struct {
-	Handlers map[string]grpcroute.HandleProtoStream
-	sync.RWMutex
+	handlers RouteTable[grpcroute.HandleProtoStream]
 }
 
 func (option *GrpcRouteOptionStream) GetHandler(key string) (grpcroute.HandleProtoStream, bool) {
-	option.RLock()
-	defer option.RUnlock()
-
-	handler, ok := option.Handlers[strings.ToLower(key)]
-	if ok {
-		return handler, true
-	}
-
-	return nil, false
+	return option.handlers.Get(key)
 }
 
 func (option *GrpcRouteOptionStream) SetHandler(key string, h grpcroute.HandleProtoStream) {
@@ -69,59 +43,41 @@ func (option *GrpcRouteOptionStream) SetHandler(key string, h grpcroute.HandlePr
 		return
 	}
 
-	option.Lock()
-	defer option.Unlock()
-
-	if option.Handlers == nil {
-		option.Handlers = make(map[string]grpcroute.HandleProtoStream)
-	}
-
-	option.Handlers[key] = h
+	option.handlers.Set(key, h)
 }
 
 func (option *GrpcRouteOptionStream) RemoveHandler(key string) {
-	option.Lock()
-	defer option.Unlock()
+	option.handlers.Remove(key)
+}
 
-	delete(option.Handlers, key)
+func (option *GrpcRouteOptionStream) SetCaseSensitive(caseSensitive bool) {
+	option.handlers.SetCaseSensitive(caseSensitive)
 }
 
 type GinGrpcOption struct {
 	pathToServiceName func(*gin.Context) string
-	handlers          map[string]*gingrpc.Handler
-	sync.RWMutex
+	handlers          RouteTable[*gingrpc.Handler]
 }
 
 func (option *GinGrpcOption) PathToGrpcService(c *gin.Context) string {
+	if option.pathToServiceName == nil {
+		return ""
+	}
 	return option.pathToServiceName(c)
 }
 
 func (option *GinGrpcOption) GetHandler(key string) (*gingrpc.Handler, bool) {
-	option.RLock()
-	defer option.RUnlock()
-
-	if handler, ok := option.handlers[key]; ok {
-		return handler, true
-	}
-	return nil, false
+	return option.handlers.Get(key)
 }
 
 func (option *GinGrpcOption) SetHandler(key string, handler *gingrpc.Handler) {
-	option.Lock()
-	defer option.Unlock()
-
-	if option.handlers == nil {
-		option.handlers = make(map[string]*gingrpc.Handler)
-	}
-	option.handlers[key] = handler
+	option.handlers.Set(key, handler)
 }
 
 func (option *GinGrpcOption) RemoveGrpcHandler(key string) {
-	option.Lock()
-	defer option.Unlock()
+	option.handlers.Remove(key)
+}
 
-	if option.handlers == nil {
-		return
-	}
-	delete(option.handlers, key)
+func (option *GinGrpcOption) SetCaseSensitive(caseSensitive bool) {
+	option.handlers.SetCaseSensitive(caseSensitive)
 }
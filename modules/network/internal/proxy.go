@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/metadata"
+	"io"
+)
+
+// 原样转发的一帧，不解码
+type rawFrame struct {
+	payload []byte
+}
+
+// 一帧原样收发，其他协议交给proto编码
+type frameCodec struct {
+	base encoding.Codec
+}
+
+func newFrameCodec() frameCodec {
+	return frameCodec{base: encoding.GetCodec(proto.Name)}
+}
+
+func (codec frameCodec) Marshal(v interface{}) ([]byte, error) {
+	if frame, ok := v.(*rawFrame); ok {
+		return frame.payload, nil
+	}
+	return codec.base.Marshal(v)
+}
+
+func (codec frameCodec) Unmarshal(data []byte, v interface{}) error {
+	if frame, ok := v.(*rawFrame); ok {
+		frame.payload = append(frame.payload[:0], data...)
+		return nil
+	}
+	return codec.base.Unmarshal(data, v)
+}
+
+func (codec frameCodec) Name() string {
+	return proto.Name
+}
+
+// 反向代理，没有处理者的方法原样转发给上游
+type Proxy struct {
+	target string
+	conn   *grpc.ClientConn
+	codec  frameCodec
+}
+
+// 连接上游的设置为空时不加密
+func NewProxy(target string, dialOptions []grpc.DialOption, callOptions ...grpc.CallOption) (*Proxy, error) {
+	codec := newFrameCodec()
+	options := make([]grpc.DialOption, 0, len(dialOptions)+1)
+	options = append(options, withDefaultCredentials(dialOptions)...)
+	options = append(options, grpc.WithDefaultCallOptions(append(callOptions, grpc.ForceCodec(codec))...))
+	conn, err := grpc.Dial(target, options...)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", target, err)
+	}
+
+	return &Proxy{target: target, conn: conn, codec: codec}, nil
+}
+
+func (proxy *Proxy) Close() error {
+	return proxy.conn.Close()
+}
+
+// 转发普通方法，请求已经解码过，回复原样返回
+func (proxy *Proxy) Invoke(ctx context.Context, fullMethod string, req interface{}) (interface{}, error) {
+	var header, trailer metadata.MD
+	resp := new(rawFrame)
+	err := proxy.conn.Invoke(outgoingContext(ctx), fullMethod, req, resp, grpc.Header(&header), grpc.Trailer(&trailer))
+	grpc.SetHeader(ctx, header)
+	grpc.SetTrailer(ctx, trailer)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// 转发流，两个方向各自原样转发，直到一方结束
+func (proxy *Proxy) ServeStream(ss grpc.ServerStream, fullMethod string) error {
+	ctx, cancel := context.WithCancel(ss.Context())
+	defer cancel()
+
+	cs, err := proxy.conn.NewStream(outgoingContext(ctx), &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, fullMethod)
+	if err != nil {
+		return err
+	}
+
+	// 客户端 => 上游
+	upErr := make(chan error, 1)
+	go func() {
+		for {
+			frame := new(rawFrame)
+			if err := ss.RecvMsg(frame); err != nil {
+				if err == io.EOF {
+					err = cs.CloseSend()
+				}
+				upErr <- err
+				return
+			}
+
+			if err := cs.SendMsg(frame); err != nil {
+				upErr <- err
+				return
+			}
+		}
+	}()
+
+	// 上游 => 客户端
+	downErr := make(chan error, 1)
+	go func() {
+		for i := 0; ; i++ {
+			frame := new(rawFrame)
+			if err := cs.RecvMsg(frame); err != nil {
+				// 没有回复帧时头部随状态一起发给客户端
+				if i == 0 {
+					if header, headerErr := cs.Header(); headerErr == nil {
+						ss.SetHeader(header)
+					}
+				}
+				downErr <- err
+				return
+			}
+
+			// 第一帧之前先把上游的头部发给客户端
+			if i == 0 {
+				if header, err := cs.Header(); err == nil {
+					if err := ss.SendHeader(header); err != nil {
+						downErr <- err
+						return
+					}
+				}
+			}
+
+			if err := ss.SendMsg(frame); err != nil {
+				downErr <- err
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case err := <-upErr:
+			if err != nil {
+				// 客户端出错，取消上游，等转发回复的协程退出后再返回
+				cancel()
+				<-downErr
+				return err
+			}
+			// 客户端发送完毕，继续等上游回复
+			upErr = nil
+		case err := <-downErr:
+			ss.SetTrailer(cs.Trailer())
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// 客户端的metadata原样带给上游
+func outgoingContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return metadata.NewOutgoingContext(ctx, md.Copy())
+}
@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+)
+
+// 在内存里启动一个grpc服务，返回连接它的设置
+func serveBufconn(t *testing.T, server *grpc.Server) []grpc.DialOption {
+	lis := bufconn.Listen(1 << 20)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	return []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+	}
+}
+
+func TestProxyForwardsHeaderWithoutFrames(t *testing.T) {
+	upstream := grpc.NewServer(grpc.UnknownServiceHandler(func(_ interface{}, ss grpc.ServerStream) error {
+		ss.SetHeader(metadata.Pairs("x-upstream", "1"))
+		return status.Error(codes.PermissionDenied, "denied")
+	}))
+
+	proxy, err := NewProxy("bufnet", serveBufconn(t, upstream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	front := grpc.NewServer(grpc.ForceServerCodec(proxy.codec), grpc.UnknownServiceHandler(func(_ interface{}, ss grpc.ServerStream) error {
+		fullMethod, _ := grpc.MethodFromServerStream(ss)
+		return proxy.ServeStream(ss, fullMethod)
+	}))
+	conn, err := grpc.Dial("bufnet", append(serveBufconn(t, front), grpc.WithDefaultCallOptions(grpc.ForceCodec(proxy.codec)))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	cs, err := conn.NewStream(context.Background(), &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, "/pkg.Service/Method")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.CloseSend()
+
+	if err := cs.RecvMsg(new(rawFrame)); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("err = %v, want PermissionDenied", err)
+	}
+
+	header, _ := cs.Header()
+	if got := header.Get("x-upstream"); len(got) != 1 || got[0] != "1" {
+		t.Fatalf("header = %v, want x-upstream from upstream", header)
+	}
+}
@@ -0,0 +1,389 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	gingrpc "github.com/dan-and-dna/gin-grpc"
+	"github.com/dan-and-dna/gin-grpc-network/utils"
+	"google.golang.org/grpc"
+	"log"
+	"sort"
+	"strings"
+)
+
+// 注册失败的原因
+var (
+	ErrBadRegister       = errors.New("bad register")               // 方法名为空或者处理者为空
+	ErrDuplicateRegister = errors.New("duplicate register")         // 方法已经注册过
+	ErrRegisterConflict  = errors.New("unary and stream conflict")  // 同一个方法既注册了普通处理者又注册了流处理者
+	ErrMethodNotInDesc   = errors.New("method not in service desc") // 服务描述里没有这个方法
+)
+
+// 一次注册，路由模式变化时用来重建路由表
+type registration struct {
+	pkg               string
+	service           string
+	method            string
+	fullMethod        string // grpc的完整方法名，有服务描述时以服务描述为准
+	desc              *grpc.ServiceDesc
+	handler           *gingrpc.Handler               // 普通处理者
+	listener          func(grpc.ServerStream) error  // 流处理者
+	middlewares       []grpc.UnaryServerInterceptor  // 方法的中间件
+	middlewaresStream []grpc.StreamServerInterceptor // 方法的流中间件
+	release           func()                         // 取消注册时释放资源，比如远程服务的连接
+	remote            bool                           // 由转发注册，停止转发时只取消这些注册
+	respType          string                         // 回复的协议，注册时知道就填写，路由表使用
+}
+
+// 当前路由模式下的key
+func (network *Network) routeKey(fullMethod string) string {
+	return utils.NormalizeKey(fullMethod, network.caseSensitive)
+}
+
+// 按当前路由模式找到注册，调用者需要持有 network.mu
+func (network *Network) findRegistration(pkg, service, method string) (*registration, bool) {
+	key := network.routeKey(utils.MakeFullMethod(pkg, service, method))
+	for _, reg := range network.registrations {
+		if network.routeKey(reg.fullMethod) == key || network.routeKey(utils.MakeFullMethod(reg.pkg, reg.service, reg.method)) == key {
+			return reg, true
+		}
+	}
+
+	return nil, false
+}
+
+// 注册一个处理者或者监听者，调用者需要持有 network.mu
+func (network *Network) register(reg *registration) error {
+	reg.fullMethod = canonicalFullMethod(reg.pkg, reg.service, reg.method, reg.desc)
+	if err := network.checkRegister(reg); err != nil {
+		return network.registerFailed(err)
+	}
+
+	// 注册前按调用者的写法设置的灰度规则和镜像，改用注册的完整方法名
+	if raw := utils.MakeFullMethod(reg.pkg, reg.service, reg.method); raw != reg.fullMethod {
+		moveRoute(&network.canaries, raw, reg.fullMethod)
+		moveRoute(&network.mirrors, raw, reg.fullMethod)
+	}
+
+	network.registrations[reg.fullMethod] = reg
+	network.install(reg)
+	if reg.desc != nil {
+		network.grpcServiceDescMap[reg.desc.ServiceName] = reg.desc
+	}
+
+	return nil
+}
+
+// 取消注册，调用者需要持有 network.mu
+func (network *Network) unregister(pkg, service, method string, isStream bool) {
+	reg, ok := network.findRegistration(pkg, service, method)
+	if !ok || (reg.listener != nil) != isStream {
+		return
+	}
+
+	network.uninstall(reg)
+	delete(network.registrations, reg.fullMethod)
+	network.dropUnusedDesc(reg)
+	if reg.release != nil {
+		reg.release()
+	}
+}
+
+// 服务的方法都取消注册后删除服务描述，重启后grpc不再注册这个服务，调用者需要持有 network.mu
+func (network *Network) dropUnusedDesc(removed *registration) {
+	serviceKey := network.routeKey(serviceKeyOf(removed.fullMethod))
+	for _, reg := range network.registrations {
+		if network.routeKey(serviceKeyOf(reg.fullMethod)) == serviceKey {
+			return
+		}
+	}
+
+	for serviceName := range network.grpcServiceDescMap {
+		if network.routeKey("/"+serviceName+"/") == serviceKey {
+			delete(network.grpcServiceDescMap, serviceName)
+		}
+	}
+}
+
+// 检查能否注册
+func (network *Network) checkRegister(reg *registration) error {
+	isStream := reg.listener != nil
+	if reg.pkg == "" || reg.service == "" || reg.method == "" {
+		return fmt.Errorf("%w: %s", ErrBadRegister, reg.fullMethod)
+	}
+
+	if exists, ok := network.findRegistration(reg.pkg, reg.service, reg.method); ok {
+		if (exists.listener != nil) == isStream {
+			return fmt.Errorf("%w: %s", ErrDuplicateRegister, reg.fullMethod)
+		}
+		return fmt.Errorf("%w: %s", ErrRegisterConflict, reg.fullMethod)
+	}
+
+	desc := reg.desc
+	if desc == nil {
+		return nil
+	}
+
+	if !strings.EqualFold(desc.ServiceName, reg.pkg+"."+reg.service) {
+		return fmt.Errorf("%w: %s not in %s", ErrMethodNotInDesc, reg.fullMethod, desc.ServiceName)
+	}
+
+	for _, methodDesc := range desc.Methods {
+		if strings.EqualFold(methodDesc.MethodName, reg.method) {
+			if isStream {
+				return fmt.Errorf("%w: %s is unary in %s", ErrRegisterConflict, reg.fullMethod, desc.ServiceName)
+			}
+			return nil
+		}
+	}
+
+	for _, streamDesc := range desc.Streams {
+		if strings.EqualFold(streamDesc.StreamName, reg.method) {
+			if !isStream {
+				return fmt.Errorf("%w: %s is stream in %s", ErrRegisterConflict, reg.fullMethod, desc.ServiceName)
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s not in %s", ErrMethodNotInDesc, reg.fullMethod, desc.ServiceName)
+}
+
+// 把注册放进路由表，路由表自己按模式规范化方法名
+func (network *Network) install(reg *registration) {
+	key := network.routeKey(reg.fullMethod)
+	if reg.listener != nil {
+		network.routeMiddlewares.SetMethodStream(key, reg.middlewaresStream)
+		listener := network.wrapListener(key, streamInfo(reg.desc, reg.fullMethod, reg.method), reg.listener)
+		network.grpcRouteOptionStream.SetHandler(reg.fullMethod, network.timeoutListener(key, reg.fullMethod, listener))
+		return
+	}
+
+	handler := *reg.handler
+	network.routeMiddlewares.SetMethod(key, reg.middlewares)
+	// 由内到外：灰度版本、路由中间件、镜像、订阅、超时
+	handle := network.canaryHandler(reg.fullMethod, handler.HandleProto)
+	handle = network.wrapHandler(key, reg.fullMethod, handle)
+	handle = network.mirrorHandler(reg.fullMethod, handle)
+	handle = network.publishHandler(reg.fullMethod, handle)
+	handler.HandleProto = network.timeoutHandler(key, reg.fullMethod, handle)
+	network.ginGrpcOption.SetHandler(reg.fullMethod, &handler)
+	network.grpcRouteOption.SetHandler(reg.fullMethod, handler.HandleProto)
+}
+
+// 把注册从路由表中移除
+func (network *Network) uninstall(reg *registration) {
+	key := network.routeKey(reg.fullMethod)
+	if reg.listener != nil {
+		network.grpcRouteOptionStream.RemoveHandler(reg.fullMethod)
+		network.routeMiddlewares.SetMethodStream(key, nil)
+		return
+	}
+
+	network.ginGrpcOption.RemoveGrpcHandler(reg.fullMethod)
+	network.grpcRouteOption.RemoveHandler(reg.fullMethod)
+	network.routeMiddlewares.SetMethod(key, nil)
+}
+
+// 切换路由模式并重建路由表，调用者需要持有 network.mu
+func (network *Network) setCaseSensitive(caseSensitive bool) {
+	if network.caseSensitive == caseSensitive {
+		return
+	}
+
+	for _, reg := range network.registrations {
+		network.uninstall(reg)
+	}
+
+	network.caseSensitive = caseSensitive
+	network.ginGrpcOption.SetCaseSensitive(caseSensitive)
+	network.grpcRouteOption.SetCaseSensitive(caseSensitive)
+	network.grpcRouteOptionStream.SetCaseSensitive(caseSensitive)
+	network.routeMiddlewares.SetCaseSensitive(caseSensitive)
+	network.mirrors.SetCaseSensitive(caseSensitive)
+	network.canaries.SetCaseSensitive(caseSensitive)
+
+	// 按名字排序，冲突时保留的注册是确定的
+	fullMethods := make([]string, 0, len(network.registrations))
+	for fullMethod := range network.registrations {
+		fullMethods = append(fullMethods, fullMethod)
+	}
+	sort.Strings(fullMethods)
+
+	installed := make(map[string]struct{}, len(fullMethods))
+	for _, fullMethod := range fullMethods {
+		reg := network.registrations[fullMethod]
+		key := network.routeKey(fullMethod)
+		if _, ok := installed[key]; ok {
+			// 启动后也要记录，重启时交给严格模式检查
+			err := fmt.Errorf("%w: %s", ErrDuplicateRegister, fullMethod)
+			network.registerErrs = append(network.registerErrs, err)
+			log.Println("[network] failed to register:", err)
+			delete(network.registrations, fullMethod)
+			network.dropUnusedDesc(reg)
+			if reg.release != nil {
+				reg.release()
+			}
+			continue
+		}
+
+		installed[key] = struct{}{}
+		network.install(reg)
+	}
+}
+
+// 只差大小写的方法名，切换到区分大小写的模式前需要确认
+func (network *Network) CaseCollisions() [][]string {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	names := make(map[string]map[string]struct{})
+	add := func(fullMethod string) {
+		key := strings.ToLower(fullMethod)
+		if names[key] == nil {
+			names[key] = make(map[string]struct{})
+		}
+		names[key][fullMethod] = struct{}{}
+	}
+
+	for fullMethod := range network.registrations {
+		add(fullMethod)
+	}
+
+	for serviceName, desc := range network.grpcServiceDescMap {
+		for _, methodDesc := range desc.Methods {
+			add("/" + serviceName + "/" + methodDesc.MethodName)
+		}
+		for _, streamDesc := range desc.Streams {
+			add("/" + serviceName + "/" + streamDesc.StreamName)
+		}
+	}
+
+	var collisions [][]string
+	for _, group := range names {
+		if len(group) < 2 {
+			continue
+		}
+
+		collision := make([]string, 0, len(group))
+		for fullMethod := range group {
+			collision = append(collision, fullMethod)
+		}
+		sort.Strings(collision)
+		collisions = append(collisions, collision)
+	}
+	sort.Slice(collisions, func(i, j int) bool {
+		return collisions[i][0] < collisions[j][0]
+	})
+
+	return collisions
+}
+
+// 方法在路由表里的key，注册过时用注册的完整方法名，否则按服务描述，调用者需要持有 network.mu
+func (network *Network) methodFullName(pkg, service, method string) string {
+	if reg, ok := network.findRegistration(pkg, service, method); ok {
+		return reg.fullMethod
+	}
+
+	for serviceName, desc := range network.grpcServiceDescMap {
+		if strings.EqualFold(serviceName, pkg+"."+service) {
+			return canonicalFullMethod(pkg, service, method, desc)
+		}
+	}
+
+	return utils.MakeFullMethod(pkg, service, method)
+}
+
+// 把路由表中的一项换个key，新的key已经有时保留新的
+func moveRoute[T any](table *RouteTable[T], from, to string) {
+	value, ok := table.Get(from)
+	if !ok {
+		return
+	}
+	if _, exists := table.Get(to); exists {
+		return
+	}
+
+	table.Remove(from)
+	table.Set(to, value)
+}
+
+// 有服务描述时用服务描述里的名字，保证和grpc的完整方法名一致
+func canonicalFullMethod(pkg, service, method string, desc *grpc.ServiceDesc) string {
+	if desc == nil || !strings.EqualFold(desc.ServiceName, pkg+"."+service) {
+		return utils.MakeFullMethod(pkg, service, method)
+	}
+
+	for _, methodDesc := range desc.Methods {
+		if strings.EqualFold(methodDesc.MethodName, method) {
+			return "/" + desc.ServiceName + "/" + methodDesc.MethodName
+		}
+	}
+
+	for _, streamDesc := range desc.Streams {
+		if strings.EqualFold(streamDesc.StreamName, method) {
+			return "/" + desc.ServiceName + "/" + streamDesc.StreamName
+		}
+	}
+
+	return utils.MakeFullMethod(pkg, service, method)
+}
+
+// 服务已经有描述时用描述里的名字
+func (network *Network) canonicalServiceKey(pkg, service string) string {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	for serviceName := range network.grpcServiceDescMap {
+		if strings.EqualFold(serviceName, pkg+"."+service) {
+			return "/" + serviceName + "/"
+		}
+	}
+
+	return utils.MakeServiceKey(pkg, service)
+}
+
+// 记录注册失败，调用者需要持有 network.mu
+// 第一次启动前的失败留给严格模式检查，启动后的失败已经返回给调用者，不再记录
+func (network *Network) registerFailed(err error) error {
+	if !network.started {
+		network.registerErrs = append(network.registerErrs, err)
+	}
+	log.Println("[network] failed to register:", err)
+	return err
+}
+
+// 注册前的校验失败，和注册失败一样记录，调用者不能持有 network.mu
+func (network *Network) rejectRegister(err error) error {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	return network.registerFailed(err)
+}
+
+// 取出并清空注册失败的记录，调用者需要持有 network.mu
+func (network *Network) takeRegisterErrs() []error {
+	errs := network.registerErrs
+	network.registerErrs = nil
+	return errs
+}
+
+// 切换到不区分大小写时会被合并掉的注册，调用者需要持有 network.mu
+func (network *Network) caseInsensitiveDrops() []string {
+	fullMethods := make([]string, 0, len(network.registrations))
+	for fullMethod := range network.registrations {
+		fullMethods = append(fullMethods, fullMethod)
+	}
+	sort.Strings(fullMethods)
+
+	var drops []string
+	seen := make(map[string]struct{}, len(fullMethods))
+	for _, fullMethod := range fullMethods {
+		key := strings.ToLower(fullMethod)
+		if _, ok := seen[key]; ok {
+			drops = append(drops, fullMethod)
+			continue
+		}
+		seen[key] = struct{}{}
+	}
+	return drops
+}
@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	gingrpc "github.com/dan-and-dna/gin-grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"testing"
+)
+
+func newTestNetwork() *Network {
+	network := new(Network)
+	network.ModuleConstruct()
+	return network
+}
+
+func testHandler() gingrpc.Handler {
+	return gingrpc.Handler{HandleProto: func(context.Context, interface{}) (interface{}, error) { return nil, nil }}
+}
+
+func TestStrictRegisterReload(t *testing.T) {
+	network := newTestNetwork()
+	network.core.StrictRegister = true
+	network.core.CaseSensitive = true
+	if err := network.Recreate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := network.HandleProto("pkg", "Service", "Method", nil, testHandler()); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.HandleProto("pkg", "Service", "METHOD", nil, testHandler()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 启动后注册失败，错误返回给调用者，不影响重启
+	if err := network.HandleProto("pkg", "Service", "Method", nil, testHandler()); !errors.Is(err, ErrDuplicateRegister) {
+		t.Fatalf("err = %v", err)
+	}
+	if err := network.Recreate(); err != nil {
+		t.Fatalf("reload after a runtime register error: %v", err)
+	}
+
+	// 切换到不区分大小写会合并掉注册，UpdateCfg 直接拒绝
+	cfg := network.GetConfig()
+	cfg.CaseSensitive = false
+	if err := network.UpdateCfg(cfg); !errors.Is(err, ErrDuplicateRegister) {
+		t.Fatalf("err = %v", err)
+	}
+
+	// 绕过 UpdateCfg 时重启返回错误，不会 panic
+	network.core.CaseSensitive = false
+	if err := network.Recreate(); !errors.Is(err, ErrBadRegister) {
+		t.Fatalf("err = %v", err)
+	}
+	if err := network.Recreate(); err != nil {
+		t.Fatalf("errors should be cleared after the check: %v", err)
+	}
+}
+
+func TestStrictRegisterFirstStart(t *testing.T) {
+	network := newTestNetwork()
+	network.core.StrictRegister = true
+	network.HandleProto("pkg", "Service", "Method", nil, gingrpc.Handler{})
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("first start with register errors should panic")
+		}
+	}()
+	network.Recreate()
+}
+
+func TestValidationErrorsRecorded(t *testing.T) {
+	network := newTestNetwork()
+	files := testRemoteFiles(t)
+	dynamic := func(context.Context, *dynamicpb.Message) (proto.Message, error) { return nil, nil }
+
+	cases := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"service nil", network.RegisterService(nil, nil), ErrBadRegister},
+		{"service impl", network.RegisterService(&grpc_health_v1.Health_ServiceDesc, struct{}{}), ErrBadRegister},
+		{"dynamic nil", network.HandleDynamic(nil, "remote", "Echo", "Say", dynamic), ErrBadRegister},
+		{"dynamic method", network.HandleDynamic(files, "remote", "Echo", "Nope", dynamic), ErrMethodNotInDesc},
+		{"dynamic service", network.HandleDynamic(files, "remote", "Nope", "Say", dynamic), ErrMethodNotInDesc},
+		{"remote method", network.HandleRemote("remote", "Echo", "Nope", "127.0.0.1:1", RemoteOption{Files: files}), ErrMethodNotInDesc},
+		{"remote service", network.HandleRemoteService("remote.Nope", "127.0.0.1:1", RemoteOption{Files: files}), ErrMethodNotInDesc},
+	}
+
+	for _, c := range cases {
+		if !errors.Is(c.err, c.want) {
+			t.Errorf("%s: err = %v, want %v", c.name, c.err, c.want)
+		}
+	}
+
+	// 启动前的校验失败也留给严格模式检查
+	network.mu.Lock()
+	errs := network.takeRegisterErrs()
+	network.mu.Unlock()
+	if len(errs) != len(cases) {
+		t.Fatalf("recorded %d register errors, want %d: %v", len(errs), len(cases), errs)
+	}
+}
+
+// 按grpc重启，返回grpc.Server上注册的服务
+func recreateGrpcServices(t *testing.T, network *Network) map[string]grpc.ServiceInfo {
+	network.core.ListenGrpc = true
+	network.core.ListenIp = "127.0.0.1"
+	if err := network.Recreate(); err != nil {
+		t.Fatal(err)
+	}
+	defer network.grpcListener.Close()
+
+	return network.grpcSrv.GetServiceInfo()
+}
+
+func TestUnregisterDropsUnusedDesc(t *testing.T) {
+	network := newTestNetwork()
+	desc := &grpc_health_v1.Health_ServiceDesc
+	if err := network.HandleProto("grpc.health.v1", "Health", "Check", desc, testHandler()); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.ListenProto("grpc.health.v1", "Health", "Watch", desc, func(grpc.ServerStream) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := recreateGrpcServices(t, network)["grpc.health.v1.Health"]; !ok {
+		t.Fatal("service not registered on start")
+	}
+
+	// 还有方法时保留服务描述
+	network.StopHandleProto("grpc.health.v1", "Health", "Check")
+	if _, ok := network.grpcServiceDescMap["grpc.health.v1.Health"]; !ok {
+		t.Fatal("desc dropped while Watch is still registered")
+	}
+	if _, ok := recreateGrpcServices(t, network)["grpc.health.v1.Health"]; !ok {
+		t.Fatal("service not registered after a partial unregister")
+	}
+
+	// 方法都取消后删除服务描述，重启后grpc不再注册这个服务
+	network.StopListenProto("grpc.health.v1", "Health", "Watch")
+	if _, ok := network.grpcServiceDescMap["grpc.health.v1.Health"]; ok {
+		t.Fatal("desc kept after every method was unregistered")
+	}
+	if _, ok := recreateGrpcServices(t, network)["grpc.health.v1.Health"]; ok {
+		t.Fatal("service registered after every method was unregistered")
+	}
+
+	// 再注册时恢复
+	if err := network.HandleProto("grpc.health.v1", "Health", "Check", desc, testHandler()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := recreateGrpcServices(t, network)["grpc.health.v1.Health"]; !ok {
+		t.Fatal("service not registered after registering again")
+	}
+}
@@ -0,0 +1,289 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	gingrpc "github.com/dan-and-dna/gin-grpc"
+	"github.com/dan-and-dna/gin-grpc-network/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// http的逐跳头部，不能作为grpc的metadata转发
+var hopHeaders = []string{"connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade", "host", "content-length", "accept-encoding"}
+
+// 转发的设置
+type RemoteOption struct {
+	Files       *protoregistry.Files          // 查找服务描述，为空时用 protoregistry.GlobalFiles
+	DialOptions []grpc.DialOption             // 连接远程服务的设置，为空时不加密
+	Middlewares []grpc.UnaryServerInterceptor // 方法的中间件
+}
+
+// 一个远程grpc服务的连接
+type remoteConn struct {
+	conn        *grpc.ClientConn
+	dialOptions []grpc.DialOption // 连接时的设置
+	refs        int               // 使用这个连接的路由数
+}
+
+// 远程grpc服务的连接，按地址和连接设置共享，没有路由使用时关闭
+type RemoteConns struct {
+	conns map[string]*remoteConn
+	mu    sync.Mutex
+}
+
+// 获得连接，用完调用 release，dialOptions 为空时不加密
+// 同一个地址、同样的设置共享连接；设置变了重新连接，旧的连接在使用它的路由都释放后关闭
+func (conns *RemoteConns) acquire(target string, dialOptions []grpc.DialOption) (*grpc.ClientConn, func(), error) {
+	conns.mu.Lock()
+	defer conns.mu.Unlock()
+
+	remote, ok := conns.conns[target]
+	if !ok || !sameDialOptions(remote.dialOptions, dialOptions) {
+		conn, err := grpc.Dial(target, withDefaultCredentials(dialOptions)...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("remote %s: %w", target, err)
+		}
+
+		if conns.conns == nil {
+			conns.conns = make(map[string]*remoteConn)
+		}
+		remote = &remoteConn{conn: conn, dialOptions: append([]grpc.DialOption(nil), dialOptions...)}
+		conns.conns[target] = remote
+	}
+
+	remote.refs++
+	var once sync.Once
+	return remote.conn, func() { once.Do(func() { conns.release(target, remote) }) }, nil
+}
+
+// 连接设置是否相同，grpc的设置大多是指针，比较不了的按不同处理
+func sameDialOptions(a, b []grpc.DialOption) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		typ := reflect.TypeOf(a[i])
+		if typ != reflect.TypeOf(b[i]) || (typ != nil && !typ.Comparable()) || a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// 没有连接设置时不加密
+func withDefaultCredentials(options []grpc.DialOption) []grpc.DialOption {
+	if len(options) == 0 {
+		return []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	return options
+}
+
+func (conns *RemoteConns) release(target string, remote *remoteConn) {
+	conns.mu.Lock()
+	defer conns.mu.Unlock()
+
+	remote.refs--
+	if remote.refs > 0 {
+		return
+	}
+
+	remote.conn.Close()
+	// 设置变了之后地址对应的已经是新的连接
+	if conns.conns[target] == remote {
+		delete(conns.conns, target)
+	}
+}
+
+// 方法转发给远程的grpc服务，http收到的json按请求的类型解码，回复按json返回
+func (network *Network) HandleRemote(pkg, service, method, target string, option RemoteOption) error {
+	files := option.Files
+	if files == nil {
+		files = protoregistry.GlobalFiles
+	}
+
+	serviceDesc, err := findServiceDescriptor(files, pkg+"."+service)
+	if err != nil {
+		return network.rejectRegister(fmt.Errorf("%w: %v", ErrMethodNotInDesc, err))
+	}
+
+	methodDesc := serviceDesc.Methods().ByName(protoreflect.Name(method))
+	if methodDesc == nil {
+		return network.rejectRegister(fmt.Errorf("%w: %s not in %s", ErrMethodNotInDesc, method, serviceDesc.FullName()))
+	}
+
+	if methodDesc.IsStreamingClient() || methodDesc.IsStreamingServer() {
+		return network.rejectRegister(fmt.Errorf("%w: %s/%s is stream", ErrRegisterConflict, serviceDesc.FullName(), method))
+	}
+
+	reqType := remoteMessageType(option.Files, methodDesc.Input())
+	respType := remoteMessageType(option.Files, methodDesc.Output())
+
+	conn, release, err := network.remoteConns.acquire(target, option.DialOptions)
+	if err != nil {
+		return network.rejectRegister(fmt.Errorf("%w: dial %s: %v", ErrBadRegister, target, err))
+	}
+
+	fullMethod := "/" + string(serviceDesc.FullName()) + "/" + method
+	handler := &gingrpc.Handler{
+		Proto: reqType.New().Interface(),
+		HandleProto: func(ctx context.Context, req interface{}) (interface{}, error) {
+			resp := respType.New().Interface()
+			if err := conn.Invoke(remoteContext(ctx), fullMethod, req, resp, network.compressOptions()...); err != nil {
+				return nil, err
+			}
+			return resp, nil
+		},
+	}
+
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	err = network.register(&registration{
+		pkg:         pkg,
+		service:     service,
+		method:      method,
+		handler:     handler,
+		middlewares: option.Middlewares,
+		release:     release,
+		remote:      true,
+		respType:    string(methodDesc.Output().FullName()),
+	})
+	if err != nil {
+		release()
+	}
+	return err
+}
+
+// 没有自己的描述时优先用编译进来的类型，否则按描述动态构造
+func remoteMessageType(files *protoregistry.Files, desc protoreflect.MessageDescriptor) protoreflect.MessageType {
+	if files == nil {
+		if messageType, err := protoregistry.GlobalTypes.FindMessageByName(desc.FullName()); err == nil {
+			return messageType
+		}
+	}
+	return dynamicpb.NewMessageType(desc)
+}
+
+// 整个服务转发给远程的grpc服务，流方法不转发
+func (network *Network) HandleRemoteService(serviceName, target string, option RemoteOption) error {
+	pkg, service, err := splitServiceName(serviceName)
+	if err != nil {
+		return network.rejectRegister(fmt.Errorf("%w: %v", ErrBadRegister, err))
+	}
+
+	files := option.Files
+	if files == nil {
+		files = protoregistry.GlobalFiles
+	}
+
+	serviceDesc, err := findServiceDescriptor(files, serviceName)
+	if err != nil {
+		return network.rejectRegister(fmt.Errorf("%w: %v", ErrMethodNotInDesc, err))
+	}
+
+	var registered []string
+	methods := serviceDesc.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		method := methods.Get(i)
+		if method.IsStreamingClient() || method.IsStreamingServer() {
+			continue
+		}
+
+		if err := network.HandleRemote(pkg, service, string(method.Name()), target, option); err != nil {
+			// 注册失败时撤销已经注册的方法
+			network.mu.Lock()
+			for _, name := range registered {
+				network.unregisterRemote(pkg, service, name)
+			}
+			network.mu.Unlock()
+			return err
+		}
+		registered = append(registered, string(method.Name()))
+	}
+
+	return nil
+}
+
+// 停止转发整个服务，只取消转发注册的方法，本地的处理者不受影响
+func (network *Network) StopRemoteService(serviceName string) {
+	pkg, service, err := splitServiceName(serviceName)
+	if err != nil {
+		return
+	}
+
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	serviceKey := network.routeKey(utils.MakeServiceKey(pkg, service))
+	var methods []string
+	for _, reg := range network.registrations {
+		if reg.remote && network.routeKey(serviceKeyOf(reg.fullMethod)) == serviceKey {
+			methods = append(methods, reg.method)
+		}
+	}
+
+	for _, method := range methods {
+		network.unregisterRemote(pkg, service, method)
+	}
+}
+
+// 取消转发注册的方法，调用者需要持有 network.mu
+func (network *Network) unregisterRemote(pkg, service, method string) {
+	if reg, ok := network.findRegistration(pkg, service, method); ok && reg.remote {
+		network.unregister(pkg, service, method, false)
+	}
+}
+
+// 按配置同步转发的服务，地址或者连接设置变化时重新注册
+func (network *Network) syncRemoteServices(remoteServices map[string]string, dialOptions []grpc.DialOption) {
+	// 连接设置变了，所有按配置转发的服务都重新连接
+	dialChanged := !sameDialOptions(network.remoteDialOptions, dialOptions)
+	network.remoteDialOptions = dialOptions
+
+	for serviceName, target := range network.remoteServices {
+		if dialChanged || remoteServices[serviceName] != target {
+			network.StopRemoteService(serviceName)
+			delete(network.remoteServices, serviceName)
+		}
+	}
+
+	serviceNames := make([]string, 0, len(remoteServices))
+	for serviceName := range remoteServices {
+		serviceNames = append(serviceNames, serviceName)
+	}
+	sort.Strings(serviceNames)
+
+	for _, serviceName := range serviceNames {
+		target := remoteServices[serviceName]
+		if _, ok := network.remoteServices[serviceName]; ok {
+			continue
+		}
+
+		if err := network.HandleRemoteService(serviceName, target, RemoteOption{DialOptions: dialOptions}); err != nil {
+			log.Println("[network] failed to forward service:", serviceName, err)
+			continue
+		}
+		network.remoteServices[serviceName] = target
+		log.Printf("[network] 服务 %s 转发给 %s\n", serviceName, target)
+	}
+}
+
+// http头部作为metadata带给远程服务，去掉逐跳头部
+func remoteContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	md = md.Copy()
+	for _, key := range hopHeaders {
+		delete(md, key)
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
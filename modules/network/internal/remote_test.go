@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"testing"
+)
+
+// 只存在于描述里的服务，没有编译进来的类型
+func testRemoteFiles(t *testing.T) *protoregistry.Files {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("remote.proto"),
+		Package: proto.String("remote"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("EchoReq")},
+			{Name: proto.String("EchoResp")},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Echo"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{Name: proto.String("Say"), InputType: proto.String(".remote.EchoReq"), OutputType: proto.String(".remote.EchoResp")},
+				{Name: proto.String("Ping"), InputType: proto.String(".remote.EchoReq"), OutputType: proto.String(".remote.EchoResp")},
+			},
+		}},
+	}
+
+	files, err := protodesc.NewFiles(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestStopRemoteServiceKeepsLocalHandlers(t *testing.T) {
+	network := newTestNetwork()
+	option := RemoteOption{Files: testRemoteFiles(t)}
+	if err := network.HandleRemote("remote", "Echo", "Say", "127.0.0.1:1", option); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.HandleProto("remote", "Echo", "Ping", nil, testHandler()); err != nil {
+		t.Fatal(err)
+	}
+
+	handler, ok := network.ginGrpcOption.GetHandler("/remote.Echo/Say")
+	if !ok {
+		t.Fatal("remote method not registered")
+	}
+	if _, ok := handler.Proto.(*dynamicpb.Message); !ok {
+		t.Fatalf("request type = %T, want *dynamicpb.Message", handler.Proto)
+	}
+
+	network.StopRemoteService("remote.Echo")
+	if _, ok := network.ginGrpcOption.GetHandler("/remote.Echo/Say"); ok {
+		t.Fatal("remote method still registered")
+	}
+	if _, ok := network.ginGrpcOption.GetHandler("/remote.Echo/Ping"); !ok {
+		t.Fatal("local handler removed by StopRemoteService")
+	}
+	if len(network.remoteConns.conns) != 0 {
+		t.Fatalf("remote connections = %d, want 0", len(network.remoteConns.conns))
+	}
+}
+
+func TestRemoteConnsDialOptions(t *testing.T) {
+	conns := new(RemoteConns)
+	target := "127.0.0.1:1"
+
+	first, releaseFirst, err := conns.acquire(target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, releaseShared, err := conns.acquire(target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shared != first {
+		t.Fatal("same target and options should share the connection")
+	}
+
+	// 设置变了重新连接，旧的连接还在使用
+	options := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithUserAgent("test")}
+	second, releaseSecond, err := conns.acquire(target, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first {
+		t.Fatal("changed options should dial a new connection")
+	}
+	again, releaseAgain, err := conns.acquire(target, append([]grpc.DialOption(nil), options...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != second {
+		t.Fatal("copied options should share the connection")
+	}
+
+	releaseFirst()
+	if first.GetState() == connectivity.Shutdown {
+		t.Fatal("closed while still in use")
+	}
+	releaseShared()
+	if first.GetState() != connectivity.Shutdown {
+		t.Fatal("old connection not closed after the last release")
+	}
+	if conns.conns[target].conn != second {
+		t.Fatal("releasing the old connection dropped the new one")
+	}
+
+	releaseSecond()
+	releaseAgain()
+	if second.GetState() != connectivity.Shutdown || len(conns.conns) != 0 {
+		t.Fatal("connection not closed after the last release")
+	}
+}
+
+func TestSyncRemoteServicesDialOptions(t *testing.T) {
+	network := newTestNetwork()
+	target := "127.0.0.1:1"
+	services := map[string]string{"grpc.health.v1.Health": target}
+	conn := func() *grpc.ClientConn {
+		remote, ok := network.remoteConns.conns[target]
+		if !ok {
+			t.Fatal("no connection to", target)
+		}
+		return remote.conn
+	}
+
+	network.syncRemoteServices(services, nil)
+	first := conn()
+	network.syncRemoteServices(services, nil)
+	if conn() != first {
+		t.Fatal("unchanged config should keep the connection")
+	}
+
+	// 重启时换了连接设置
+	options := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithUserAgent("test")}
+	network.syncRemoteServices(services, options)
+	second := conn()
+	if second == first || first.GetState() != connectivity.Shutdown {
+		t.Fatal("changed dial options were not applied")
+	}
+
+	// 配置复制后设置还是同一批
+	network.syncRemoteServices(services, append([]grpc.DialOption(nil), options...))
+	if conn() != second {
+		t.Fatal("copied dial options should keep the connection")
+	}
+	if _, ok := network.ginGrpcOption.GetHandler("/grpc.health.v1.Health/Check"); !ok {
+		t.Fatal("remote method not registered")
+	}
+}
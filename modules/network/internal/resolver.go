@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/dan-and-dna/gin-grpc-network/core"
+	"github.com/dan-and-dna/gin-grpc-network/utils"
+	"github.com/gin-gonic/gin"
+	"strings"
+)
+
+// 携带grpc方法名的http头部
+const RpcMethodHeader = "X-Rpc-Method"
+
+// /{pkg}/{service}/{method}，取路径的最后三段
+func PathToServiceByPath(c *gin.Context) string {
+	segments := splitPath(c.Request.URL.Path)
+	if len(segments) < 3 {
+		return ""
+	}
+
+	segments = segments[len(segments)-3:]
+	return utils.MakeFullMethod(segments[0], segments[1], segments[2])
+}
+
+// /{pkg}.{service}/{method}，和grpc的完整方法名一致
+func PathToServiceByGrpcPath(c *gin.Context) string {
+	return grpcPathToKey(c.Request.URL.Path)
+}
+
+// 从 X-Rpc-Method 头部读取 /{pkg}.{service}/{method}
+func PathToServiceByHeader(c *gin.Context) string {
+	return grpcPathToKey(c.GetHeader(RpcMethodHeader))
+}
+
+// 按名字获取内置的解析方式
+func GetPathResolver(name string) (func(*gin.Context) string, error) {
+	switch name {
+	case core.PathResolverPath:
+		return PathToServiceByPath, nil
+	case core.PathResolverGrpcPath:
+		return PathToServiceByGrpcPath, nil
+	case core.PathResolverHeader:
+		return PathToServiceByHeader, nil
+	case "":
+		return nil, fmt.Errorf("no http path resolver")
+	}
+
+	return nil, fmt.Errorf("unknown http path resolver: %s", name)
+}
+
+func grpcPathToKey(path string) string {
+	segments := splitPath(path)
+	if len(segments) < 2 {
+		return ""
+	}
+
+	segments = segments[len(segments)-2:]
+	dot := strings.LastIndexByte(segments[0], '.')
+	if dot <= 0 || dot == len(segments[0])-1 {
+		return ""
+	}
+
+	return utils.MakeFullMethod(segments[0][:dot], segments[0][dot+1:], segments[1])
+}
+
+func splitPath(path string) []string {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	return segments
+}
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"github.com/dan-and-dna/gin-grpc-network/core"
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPathResolvers(t *testing.T) {
+	cases := []struct {
+		resolver string
+		path     string
+		header   string
+		want     string
+	}{
+		{core.PathResolverPath, "/grpc.health.v1/Health/Check", "", "/grpc.health.v1.Health/Check"},
+		{core.PathResolverPath, "/api/v1/grpc.health.v1/Health/Check/", "", "/grpc.health.v1.Health/Check"},
+		{core.PathResolverPath, "/Health/Check", "", ""},
+		{core.PathResolverGrpcPath, "/grpc.health.v1.Health/Check", "", "/grpc.health.v1.Health/Check"},
+		{core.PathResolverGrpcPath, "/api/grpc.health.v1.Health/Check", "", "/grpc.health.v1.Health/Check"},
+		{core.PathResolverGrpcPath, "/Health/Check", "", ""},
+		{core.PathResolverGrpcPath, "/grpc.health.v1./Check", "", ""},
+		{core.PathResolverGrpcPath, "/Check", "", ""},
+		{core.PathResolverHeader, "/anything", "/grpc.health.v1.Health/Check", "/grpc.health.v1.Health/Check"},
+		{core.PathResolverHeader, "/anything", "grpc.health.v1.Health/Check", "/grpc.health.v1.Health/Check"},
+		{core.PathResolverHeader, "/grpc.health.v1.Health/Check", "", ""},
+	}
+
+	for _, c := range cases {
+		resolver, err := GetPathResolver(c.resolver)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest("POST", c.path, nil)
+		if c.header != "" {
+			ctx.Request.Header.Set(RpcMethodHeader, c.header)
+		}
+		if got := resolver(ctx); got != c.want {
+			t.Errorf("%s %s %q: got %q, want %q", c.resolver, c.path, c.header, got, c.want)
+		}
+	}
+}
+
+func TestPathResolverConfig(t *testing.T) {
+	custom := func(*gin.Context) string { return "" }
+
+	cases := []struct {
+		name string
+		cfg  *core.NetworkCore
+		ok   bool
+	}{
+		{"no resolver", &core.NetworkCore{ListenHttp: true}, false},
+		{"unknown resolver", &core.NetworkCore{ListenHttp: true, HttpPathResolver: "query"}, false},
+		{"builtin resolver", &core.NetworkCore{ListenHttp: true, HttpPathResolver: core.PathResolverGrpcPath}, true},
+		{"custom resolver", &core.NetworkCore{ListenHttp: true, HttpPathToServiceName: custom}, true},
+		{"http off", &core.NetworkCore{}, true},
+	}
+
+	for _, c := range cases {
+		if err := validateConfig(c.cfg); (err == nil) != c.ok {
+			t.Errorf("%s: err = %v", c.name, err)
+		}
+	}
+
+	// 启动时同样检查
+	network := newTestNetwork()
+	network.core.ListenHttp = true
+	network.core.ListenIp = "127.0.0.1"
+	if err := network.Recreate(); err == nil {
+		t.Fatal("started http without a path resolver")
+	}
+}
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// 流的类型
+const (
+	StreamingNone   = ""
+	StreamingClient = "client"
+	StreamingServer = "server"
+	StreamingBidi   = "bidi"
+)
+
+// 路由表中的一项
+type Route struct {
+	Key          string       `json:"key"`                    // 完整的方法名
+	Http         bool         `json:"http"`                   // 是否可以通过http调用，没有开启http时为 false
+	Grpc         bool         `json:"grpc"`                   // 是否可以通过grpc调用，没有开启grpc时为 false
+	Streaming    string       `json:"streaming"`              // 流的类型
+	RequestType  string       `json:"request_type"`           // 请求的协议
+	ResponseType string       `json:"response_type"`          // 回复的协议
+	Versions     []string     `json:"versions,omitempty"`     // 灰度的版本
+	CanaryRules  []CanaryRule `json:"canary_rules,omitempty"` // 灰度规则
+}
+
+// 列出所有已注册的方法
+func (network *Network) Routes() []Route {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	routes := make([]Route, 0, len(network.registrations))
+	for fullMethod, reg := range network.registrations {
+		// 没有服务描述的处理者和监听者，grpc也能通过不认识的服务调用
+		route := Route{
+			Key:  fullMethod,
+			Http: network.listenHttp && reg.handler != nil,
+			Grpc: network.listenGrpc && (reg.desc != nil || reg.listener != nil || (reg.handler != nil && reg.handler.Proto != nil)),
+		}
+
+		if reg.desc != nil {
+			route.Streaming = descStreaming(reg.desc, reg.method)
+			route.RequestType, route.ResponseType = messageTypes(reg.desc.ServiceName, fullMethod[strings.LastIndexByte(fullMethod, '/')+1:])
+		}
+
+		// 动态和转发的方法不在 protoregistry.GlobalFiles 里，按注册的协议展示
+		if reg.respType != "" {
+			route.ResponseType = reg.respType
+		}
+		if reg.handler != nil && reg.handler.Proto != nil {
+			route.RequestType = string(reg.handler.Proto.ProtoReflect().Descriptor().FullName())
+		}
+
+		if c, ok := network.canaries.Get(fullMethod); ok {
+			for version := range c.versions {
+				route.Versions = append(route.Versions, version)
+			}
+			sort.Strings(route.Versions)
+			for _, rule := range c.rules {
+				route.CanaryRules = append(route.CanaryRules, rule.CanaryRule)
+			}
+		}
+
+		routes = append(routes, route)
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Key < routes[j].Key
+	})
+
+	return routes
+}
+
+// 返回路由表，用于运维确认部署的方法
+func (network *Network) ServeRoutes(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"routes": network.Routes()})
+}
+
+func descStreaming(desc *grpc.ServiceDesc, method string) string {
+	for _, stream := range desc.Streams {
+		if strings.EqualFold(stream.StreamName, method) {
+			return streamingKind(stream)
+		}
+	}
+	return StreamingNone
+}
+
+func streamingKind(stream grpc.StreamDesc) string {
+	switch {
+	case stream.ClientStreams && stream.ServerStreams:
+		return StreamingBidi
+	case stream.ClientStreams:
+		return StreamingClient
+	case stream.ServerStreams:
+		return StreamingServer
+	}
+	return StreamingNone
+}
+
+func messageTypes(serviceName, methodName string) (string, string) {
+	serviceDesc, err := findServiceDescriptor(protoregistry.GlobalFiles, serviceName)
+	if err != nil {
+		return "", ""
+	}
+
+	method := serviceDesc.Methods().ByName(protoreflect.Name(methodName))
+	if method == nil {
+		return "", ""
+	}
+
+	return string(method.Input().FullName()), string(method.Output().FullName())
+}
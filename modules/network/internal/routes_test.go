@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"testing"
+)
+
+func TestRoutesReportListeningTransports(t *testing.T) {
+	network := newTestNetwork()
+	desc := &grpc.ServiceDesc{ServiceName: "pkg.Service", Methods: []grpc.MethodDesc{{MethodName: "Method"}}}
+	if err := network.HandleProto("pkg", "Service", "Method", desc, testHandler()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 只开启http时不能通过grpc调用
+	network.core.ListenHttp = true
+	network.core.HttpPathResolver = "path"
+	if err := network.Recreate(); err != nil {
+		t.Fatal(err)
+	}
+	routes := network.Routes()
+	if len(routes) != 1 || !routes[0].Http || routes[0].Grpc {
+		t.Fatalf("http only: routes = %+v", routes)
+	}
+
+	network.core.ListenHttp, network.core.ListenGrpc = false, true
+	if err := network.Recreate(); err != nil {
+		t.Fatal(err)
+	}
+	defer network.Stop()
+	routes = network.Routes()
+	if len(routes) != 1 || routes[0].Http || !routes[0].Grpc {
+		t.Fatalf("grpc only: routes = %+v", routes)
+	}
+}
+
+func TestRoutesWithoutGlobalDesc(t *testing.T) {
+	network := newTestNetwork()
+	files := testRemoteFiles(t)
+	err := network.HandleDynamic(files, "remote", "Echo", "Say", func(ctx context.Context, req *dynamicpb.Message) (proto.Message, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := network.HandleRemote("remote", "Echo", "Ping", "127.0.0.1:1", RemoteOption{Files: files}); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.ListenProto("pkg", "Service", "Watch", nil, func(grpc.ServerStream) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	network.listenGrpc = true
+	for _, route := range network.Routes() {
+		// 没有服务描述的方法也能通过grpc调用
+		if !route.Grpc {
+			t.Errorf("%s: grpc = false", route.Key)
+		}
+		if route.Key == "/pkg.Service/Watch" {
+			continue
+		}
+		if route.RequestType != "remote.EchoReq" || route.ResponseType != "remote.EchoResp" {
+			t.Errorf("%s: types = %s %s", route.Key, route.RequestType, route.ResponseType)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"github.com/dan-and-dna/gin-grpc-network/utils"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 路由表中的一项
+type routeEntry[T any] struct {
+	fullMethod string // 注册时的完整方法名
+	handler    T
+}
+
+// 路由表快照，发布后只读
+type routeSnapshot[T any] struct {
+	entries       map[string]routeEntry[T] // key为规范化的方法名
+	index         map[string]T             // 规范化的方法名和注册时的方法名都能直接命中
+	caseSensitive bool
+}
+
+func (snapshot *routeSnapshot[T]) get(fullMethod string) (T, bool) {
+	if handler, ok := snapshot.index[fullMethod]; ok {
+		return handler, true
+	}
+
+	// 大小写和注册时不一致，才需要转小写再查一次
+	if !snapshot.caseSensitive {
+		if handler, ok := snapshot.index[strings.ToLower(fullMethod)]; ok {
+			return handler, true
+		}
+	}
+
+	var zero T
+	return zero, false
+}
+
+// 写时复制的路由表，查询不加锁
+type RouteTable[T any] struct {
+	snapshot atomic.Pointer[routeSnapshot[T]]
+	mu       sync.Mutex // 写者之间互斥
+}
+
+func (table *RouteTable[T]) load() *routeSnapshot[T] {
+	if snapshot := table.snapshot.Load(); snapshot != nil {
+		return snapshot
+	}
+	return &routeSnapshot[T]{}
+}
+
+func (table *RouteTable[T]) Get(fullMethod string) (T, bool) {
+	return table.load().get(fullMethod)
+}
+
+func (table *RouteTable[T]) Set(fullMethod string, handler T) {
+	table.update(func(entries map[string]routeEntry[T], caseSensitive bool) bool {
+		entries[utils.NormalizeKey(fullMethod, caseSensitive)] = routeEntry[T]{fullMethod: fullMethod, handler: handler}
+		return caseSensitive
+	})
+}
+
+func (table *RouteTable[T]) Remove(fullMethod string) {
+	table.update(func(entries map[string]routeEntry[T], caseSensitive bool) bool {
+		delete(entries, utils.NormalizeKey(fullMethod, caseSensitive))
+		return caseSensitive
+	})
+}
+
+// 切换大小写模式，已有的项按新的模式重新规范化
+func (table *RouteTable[T]) SetCaseSensitive(caseSensitive bool) {
+	table.update(func(entries map[string]routeEntry[T], _ bool) bool {
+		normalized := make(map[string]routeEntry[T], len(entries))
+		for key, entry := range entries {
+			normalized[utils.NormalizeKey(entry.fullMethod, caseSensitive)] = entry
+			delete(entries, key)
+		}
+
+		for key, entry := range normalized {
+			entries[key] = entry
+		}
+		return caseSensitive
+	})
+}
+
+// 复制当前快照，修改后整体替换
+func (table *RouteTable[T]) update(modify func(entries map[string]routeEntry[T], caseSensitive bool) bool) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	old := table.load()
+	entries := make(map[string]routeEntry[T], len(old.entries)+1)
+	for key, entry := range old.entries {
+		entries[key] = entry
+	}
+
+	caseSensitive := modify(entries, old.caseSensitive)
+	index := make(map[string]T, 2*len(entries))
+	for key, entry := range entries {
+		index[key] = entry.handler
+		index[entry.fullMethod] = entry.handler
+	}
+
+	table.snapshot.Store(&routeSnapshot[T]{entries: entries, index: index, caseSensitive: caseSensitive})
+}
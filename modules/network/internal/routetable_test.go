@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	grpcroute "github.com/dan-and-dna/grpc-route"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 压测用的方法数量
+const benchRoutes = 256
+
+// 旧的路由表：读写锁，每次查询都转小写，只用来和 RouteTable 对比
+type lockedRouteTable struct {
+	handlers map[string]grpcroute.HandleProto
+	sync.RWMutex
+}
+
+func (table *lockedRouteTable) get(key string) (grpcroute.HandleProto, bool) {
+	table.RLock()
+	defer table.RUnlock()
+
+	handler, ok := table.handlers[strings.ToLower(key)]
+	return handler, ok
+}
+
+func benchFullMethods() []string {
+	fullMethods := make([]string, benchRoutes)
+	for i := range fullMethods {
+		fullMethods[i] = fmt.Sprintf("/bench.Service%d/Method%d", i%16, i)
+	}
+	return fullMethods
+}
+
+func benchHandler(context.Context, interface{}) (interface{}, error) {
+	return nil, nil
+}
+
+func TestRouteTable(t *testing.T) {
+	table := new(RouteTable[int])
+	table.Set("/pkg.Service/Method", 1)
+
+	if v, ok := table.Get("/pkg.service/method"); !ok || v != 1 {
+		t.Fatalf("case insensitive get = %v, %v", v, ok)
+	}
+
+	table.SetCaseSensitive(true)
+	if _, ok := table.Get("/pkg.service/method"); ok {
+		t.Fatal("case sensitive table matched a different case")
+	}
+	if v, ok := table.Get("/pkg.Service/Method"); !ok || v != 1 {
+		t.Fatalf("case sensitive get = %v, %v", v, ok)
+	}
+
+	table.Remove("/pkg.Service/Method")
+	if _, ok := table.Get("/pkg.Service/Method"); ok {
+		t.Fatal("removed route still found")
+	}
+}
+
+// 并发查询写时复制的路由表
+func BenchmarkRouteTable(b *testing.B) {
+	fullMethods := benchFullMethods()
+	table := new(RouteTable[grpcroute.HandleProto])
+	for _, fullMethod := range fullMethods {
+		table.Set(fullMethod, benchHandler)
+	}
+
+	b.ReportAllocs()
+	b.SetParallelism(4)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, ok := table.Get(fullMethods[i%benchRoutes]); !ok {
+				b.Fatal("route not found")
+			}
+			i++
+		}
+	})
+}
+
+// 并发查询旧的路由表，作为 BenchmarkRouteTable 的对照
+func BenchmarkLockedRouteTable(b *testing.B) {
+	fullMethods := benchFullMethods()
+	table := &lockedRouteTable{handlers: make(map[string]grpcroute.HandleProto, benchRoutes)}
+	for _, fullMethod := range fullMethods {
+		table.handlers[strings.ToLower(fullMethod)] = benchHandler
+	}
+
+	b.ReportAllocs()
+	b.SetParallelism(4)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, ok := table.get(fullMethods[i%benchRoutes]); !ok {
+				b.Fatal("route not found")
+			}
+			i++
+		}
+	})
+}
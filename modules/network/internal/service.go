@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	gingrpc "github.com/dan-and-dna/gin-grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"reflect"
+	"strings"
+)
+
+// 注册整个grpc服务的实现，服务的每个方法都会同时处理http和grpc请求
+func (network *Network) RegisterService(desc *grpc.ServiceDesc, impl interface{}) error {
+	if desc == nil || impl == nil {
+		return network.rejectRegister(fmt.Errorf("%w: service desc and impl must not be nil", ErrBadRegister))
+	}
+
+	if desc.HandlerType != nil {
+		handlerType := reflect.TypeOf(desc.HandlerType).Elem()
+		if !reflect.TypeOf(impl).Implements(handlerType) {
+			return network.rejectRegister(fmt.Errorf("%w: %T does not implement %s", ErrBadRegister, impl, handlerType))
+		}
+	}
+
+	pkg, service, err := splitServiceName(desc.ServiceName)
+	if err != nil {
+		return network.rejectRegister(fmt.Errorf("%w: %v", ErrBadRegister, err))
+	}
+
+	serviceDesc, err := findServiceDescriptor(protoregistry.GlobalFiles, desc.ServiceName)
+	if err != nil {
+		return network.rejectRegister(fmt.Errorf("%w: %v", ErrMethodNotInDesc, err))
+	}
+
+	// 先找齐所有请求的类型，避免只注册了一半
+	protos := make([]protoreflect.ProtoMessage, len(desc.Methods))
+	for i, methodDesc := range desc.Methods {
+		method := serviceDesc.Methods().ByName(protoreflect.Name(methodDesc.MethodName))
+		if method == nil {
+			return network.rejectRegister(fmt.Errorf("%w: %s not in %s", ErrMethodNotInDesc, methodDesc.MethodName, desc.ServiceName))
+		}
+
+		messageType, err := protoregistry.GlobalTypes.FindMessageByName(method.Input().FullName())
+		if err != nil {
+			return network.rejectRegister(fmt.Errorf("%w: request type of %s/%s: %v", ErrBadRegister, desc.ServiceName, methodDesc.MethodName, err))
+		}
+		protos[i] = messageType.New().Interface()
+	}
+
+	// 注册失败时撤销已经注册的方法
+	var registered []func()
+	rollback := func() {
+		for _, stop := range registered {
+			stop()
+		}
+	}
+
+	for i := range desc.Methods {
+		methodDesc := desc.Methods[i]
+		err := network.HandleProto(pkg, service, methodDesc.MethodName, desc, gingrpc.Handler{
+			Proto:       protos[i],
+			HandleProto: unaryServiceHandler(impl, methodDesc),
+		})
+		if err != nil {
+			rollback()
+			return err
+		}
+		registered = append(registered, func() { network.StopHandleProto(pkg, service, methodDesc.MethodName) })
+	}
+
+	for i := range desc.Streams {
+		streamDesc := desc.Streams[i]
+		err := network.ListenProto(pkg, service, streamDesc.StreamName, desc, func(ss grpc.ServerStream) error {
+			return streamDesc.Handler(impl, ss)
+		})
+		if err != nil {
+			rollback()
+			return err
+		}
+		registered = append(registered, func() { network.StopListenProto(pkg, service, streamDesc.StreamName) })
+	}
+
+	return nil
+}
+
+// 复用生成代码里的handler：解码什么也不做，由拦截器把已经解码好的请求交给实现
+func unaryServiceHandler(impl interface{}, methodDesc grpc.MethodDesc) func(context.Context, interface{}) (interface{}, error) {
+	noDecode := func(interface{}) error { return nil }
+
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		return methodDesc.Handler(impl, ctx, noDecode, func(ctx context.Context, _ interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			return handler(ctx, req)
+		})
+	}
+}
+
+func findServiceDescriptor(files *protoregistry.Files, serviceName string) (protoreflect.ServiceDescriptor, error) {
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(serviceName))
+	if err != nil {
+		return nil, fmt.Errorf("service %s: %w", serviceName, err)
+	}
+
+	serviceDesc, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", serviceName)
+	}
+
+	return serviceDesc, nil
+}
+
+// webbff.WebBFF => webbff WebBFF
+func splitServiceName(serviceName string) (string, string, error) {
+	dot := strings.LastIndexByte(serviceName, '.')
+	if dot <= 0 || dot == len(serviceName)-1 {
+		return "", "", fmt.Errorf("bad service name: %s", serviceName)
+	}
+
+	return serviceName[:dot], serviceName[dot+1:], nil
+}
@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegisterServiceRoundTrip(t *testing.T) {
+	network := newTestNetwork()
+	impl := health.NewServer()
+	impl.SetServingStatus("a", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	if err := network.RegisterService(&grpc_health_v1.Health_ServiceDesc, impl); err != nil {
+		t.Fatal(err)
+	}
+
+	// Invoke
+	resp, err := network.Invoke(context.Background(), "grpc.health.v1", "Health", "Check", &grpc_health_v1.HealthCheckRequest{Service: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.(*grpc_health_v1.HealthCheckResponse).Status != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("invoke: %v", resp)
+	}
+
+	// http，没有设置json选项时枚举输出数字
+	router := newTestRouter(t, network)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/grpc.health.v1/Health/Check", strings.NewReader(`{"service":"a"}`))
+	r.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != `{"status":2}` {
+		t.Fatalf("http: %d %s", w.Code, w.Body.String())
+	}
+
+	// grpc，包括流方法
+	client := grpc_health_v1.NewHealthClient(startTestGrpc(t, network))
+	checked, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked.Status != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("grpc: %v", checked)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watch, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{Service: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	watched, err := watch.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if watched.Status != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("watch: %v", watched)
+	}
+
+	impl.SetServingStatus("a", grpc_health_v1.HealthCheckResponse_SERVING)
+	if watched, err = watch.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	if watched.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("watch update: %v", watched)
+	}
+}
+
+func TestRegisterServiceRollback(t *testing.T) {
+	network := newTestNetwork()
+
+	// 实现和服务描述不匹配，什么都不注册
+	err := network.RegisterService(&grpc_health_v1.Health_ServiceDesc, struct{}{})
+	if !errors.Is(err, ErrBadRegister) {
+		t.Fatalf("err = %v", err)
+	}
+	if len(network.Routes()) != 0 {
+		t.Fatalf("routes after rejected register: %v", network.Routes())
+	}
+
+	// 流方法已经被占用，撤销已经注册的普通方法
+	watch := func(grpc.ServerStream) error { return nil }
+	if err := network.ListenProto("grpc.health.v1", "Health", "Watch", nil, watch); err != nil {
+		t.Fatal(err)
+	}
+	err = network.RegisterService(&grpc_health_v1.Health_ServiceDesc, health.NewServer())
+	if !errors.Is(err, ErrDuplicateRegister) {
+		t.Fatalf("err = %v", err)
+	}
+	if _, ok := network.ginGrpcOption.GetHandler("/grpc.health.v1.Health/Check"); ok {
+		t.Fatal("Check not rolled back")
+	}
+
+	routes := network.Routes()
+	if len(routes) != 1 || routes[0].Key != "/grpc.health.v1.Health/Watch" {
+		t.Fatalf("routes after rollback: %v", routes)
+	}
+}
@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"context"
+	"github.com/dan-and-dna/gin-grpc-network/utils"
+	"github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 客户端要求的处理超时，比如 500ms、2s，纯数字按毫秒处理
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+// 处理超时，方法优先，其次是服务，最后是默认
+type Timeouts struct {
+	handle   time.Duration
+	services map[string]time.Duration // /pkg.service/
+	methods  map[string]time.Duration // /pkg.service/method
+}
+
+// 配置里的超时都是毫秒
+func NewTimeouts(caseSensitive bool, handle int, services, methods map[string]int) *Timeouts {
+	timeouts := &Timeouts{
+		handle:   time.Duration(handle) * time.Millisecond,
+		services: make(map[string]time.Duration, len(services)),
+		methods:  make(map[string]time.Duration, len(methods)),
+	}
+
+	for name, timeout := range services {
+		timeouts.services[utils.NormalizeKey(normalizeServiceName(name), caseSensitive)] = time.Duration(timeout) * time.Millisecond
+	}
+
+	for name, timeout := range methods {
+		timeouts.methods[utils.NormalizeKey(normalizeMethodName(name), caseSensitive)] = time.Duration(timeout) * time.Millisecond
+	}
+
+	return timeouts
+}
+
+func (timeouts *Timeouts) Get(key string) time.Duration {
+	if timeouts == nil {
+		return 0
+	}
+
+	if timeout, ok := timeouts.methods[key]; ok {
+		return timeout
+	}
+
+	if timeout, ok := timeouts.services[serviceKeyOf(key)]; ok {
+		return timeout
+	}
+
+	return timeouts.handle
+}
+
+func noCancel() {}
+
+// 给请求加上超时，客户端要求的超时更短时以客户端为准
+func (network *Network) withTimeout(ctx context.Context, key string) (context.Context, context.CancelFunc) {
+	timeout := network.timeouts.Load().Get(key)
+	if requested, ok := requestedTimeout(ctx); ok && (timeout <= 0 || requested < timeout) {
+		timeout = requested
+	}
+
+	if timeout <= 0 {
+		return ctx, noCancel
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// 超时只通过 ctx 通知处理者，不会中断处理者
+// 处理者需要检查 ctx.Done()，不检查时要等它返回后才回复 DeadlineExceeded
+func (network *Network) timeoutHandler(key, fullMethod string, handler func(context.Context, interface{}) (interface{}, error)) func(context.Context, interface{}) (interface{}, error) {
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		ctx, cancel := network.withTimeout(ctx, key)
+		defer cancel()
+
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, deadlineExceeded(fullMethod)
+		}
+
+		resp, err := handler(ctx, req)
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, deadlineExceeded(fullMethod)
+		}
+
+		return resp, err
+	}
+}
+
+func (network *Network) timeoutListener(key, fullMethod string, listener func(grpc.ServerStream) error) func(grpc.ServerStream) error {
+	return func(ss grpc.ServerStream) error {
+		ctx, cancel := network.withTimeout(ss.Context(), key)
+		defer cancel()
+
+		if ctx.Err() == context.DeadlineExceeded {
+			return deadlineExceeded(fullMethod)
+		}
+
+		wrapped := grpc_middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = ctx
+		err := listener(wrapped)
+		if ctx.Err() == context.DeadlineExceeded {
+			return deadlineExceeded(fullMethod)
+		}
+
+		return err
+	}
+}
+
+func deadlineExceeded(fullMethod string) error {
+	return status.Errorf(codes.DeadlineExceeded, "%s: deadline exceeded", fullMethod)
+}
+
+func requestedTimeout(ctx context.Context) (time.Duration, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 0, false
+	}
+
+	values := md.Get(RequestTimeoutHeader)
+	if len(values) == 0 {
+		return 0, false
+	}
+
+	value := strings.TrimSpace(values[0])
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, ms > 0
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, false
+	}
+	return timeout, true
+}
+
+// pkg.service 或者 /pkg.service/ => /pkg.service/
+func normalizeServiceName(name string) string {
+	return "/" + strings.Trim(name, "/") + "/"
+}
+
+// pkg.service/method 或者 /pkg.service/method => /pkg.service/method
+func normalizeMethodName(name string) string {
+	return "/" + strings.Trim(name, "/")
+}
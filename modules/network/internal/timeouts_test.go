@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"context"
+	gingrpc "github.com/dan-and-dna/gin-grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeoutsPrecedence(t *testing.T) {
+	services := map[string]int{"pkg.Svc": 200}
+	methods := map[string]int{"/pkg.Svc/Fast": 50}
+
+	cases := []struct {
+		caseSensitive bool
+		key           string
+		want          time.Duration
+	}{
+		{true, "/pkg.Svc/Fast", 50 * time.Millisecond},
+		{true, "/pkg.Svc/Other", 200 * time.Millisecond},
+		{true, "/pkg.Other/Fast", time.Second},
+		{true, "/pkg.svc/fast", time.Second},
+		{false, "/pkg.svc/fast", 50 * time.Millisecond},
+		{false, "/pkg.svc/other", 200 * time.Millisecond},
+	}
+
+	for _, c := range cases {
+		timeouts := NewTimeouts(c.caseSensitive, 1000, services, methods)
+		if got := timeouts.Get(c.key); got != c.want {
+			t.Errorf("%s (case sensitive %v): got %v, want %v", c.key, c.caseSensitive, got, c.want)
+		}
+	}
+
+	if got := (*Timeouts)(nil).Get("/pkg.Svc/Fast"); got != 0 {
+		t.Fatalf("nil timeouts: got %v", got)
+	}
+}
+
+func TestRequestedTimeout(t *testing.T) {
+	cases := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"500ms", 500 * time.Millisecond, true},
+		{"2s", 2 * time.Second, true},
+		{"300", 300 * time.Millisecond, true},
+		{" 300 ", 300 * time.Millisecond, true},
+		{"0", 0, false},
+		{"-5ms", 0, false},
+		{"soon", 0, false},
+	}
+
+	for _, c := range cases {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(RequestTimeoutHeader, c.value))
+		got, ok := requestedTimeout(ctx)
+		if got != c.want || ok != c.ok {
+			t.Errorf("%q: got %v %v, want %v %v", c.value, got, ok, c.want, c.ok)
+		}
+	}
+
+	if _, ok := requestedTimeout(context.Background()); ok {
+		t.Fatal("timeout without metadata")
+	}
+}
+
+func TestWithTimeoutShorterRequest(t *testing.T) {
+	network := newTestNetwork()
+	network.timeouts.Store(NewTimeouts(true, 1000, nil, nil))
+
+	cases := []struct {
+		requested string
+		want      time.Duration
+	}{
+		{"", time.Second},
+		{"20ms", 20 * time.Millisecond},
+		// 客户端要求的更长时以配置为准
+		{"5s", time.Second},
+	}
+
+	for _, c := range cases {
+		ctx := context.Background()
+		if c.requested != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(RequestTimeoutHeader, c.requested))
+		}
+
+		ctx, cancel := network.withTimeout(ctx, "/pkg.Svc/Method")
+		deadline, ok := ctx.Deadline()
+		cancel()
+		if !ok {
+			t.Fatalf("%q: no deadline", c.requested)
+		}
+		if left := time.Until(deadline); left > c.want || left < c.want-100*time.Millisecond {
+			t.Errorf("%q: deadline in %v, want %v", c.requested, left, c.want)
+		}
+	}
+}
+
+// 等到超时才返回的处理者，记录收到的截止时间
+func waitingHandler(deadlines chan<- time.Duration) gingrpc.Handler {
+	return gingrpc.Handler{
+		Proto: &grpc_health_v1.HealthCheckRequest{},
+		HandleProto: func(ctx context.Context, req interface{}) (interface{}, error) {
+			deadline, _ := ctx.Deadline()
+			deadlines <- time.Until(deadline)
+			<-ctx.Done()
+			return &grpc_health_v1.HealthCheckResponse{}, nil
+		},
+	}
+}
+
+func TestGrpcTimeoutHonored(t *testing.T) {
+	network := newTestNetwork()
+	network.core.HandleTimeOut = 10000
+	deadlines := make(chan time.Duration, 1)
+	if err := network.HandleProto("grpc.health.v1", "Health", "Check", nil, waitingHandler(deadlines)); err != nil {
+		t.Fatal(err)
+	}
+	conn := startTestGrpc(t, network)
+
+	// grpc-timeout 比配置的短，以客户端为准
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("err = %v", err)
+	}
+	if left := <-deadlines; left > 50*time.Millisecond {
+		t.Fatalf("handler deadline in %v, want at most 50ms", left)
+	}
+}
+
+func TestHttpTimeout(t *testing.T) {
+	network := newTestNetwork()
+	deadlines := make(chan time.Duration, 1)
+	if err := network.HandleProto("grpc.health.v1", "Health", "Check", nil, waitingHandler(deadlines)); err != nil {
+		t.Fatal(err)
+	}
+	router := newTestRouter(t, network)
+
+	cases := []struct {
+		name      string
+		handle    int
+		requested string
+		want      time.Duration
+	}{
+		{"configured", 20, "", 20 * time.Millisecond},
+		{"requested", 10000, "20ms", 20 * time.Millisecond},
+	}
+
+	for _, c := range cases {
+		network.timeouts.Store(NewTimeouts(true, c.handle, nil, nil))
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/grpc.health.v1/Health/Check", strings.NewReader(`{}`))
+		r.Header.Set("Content-Type", "application/json")
+		if c.requested != "" {
+			r.Header.Set(RequestTimeoutHeader, c.requested)
+		}
+		router.ServeHTTP(w, r)
+
+		if w.Code != http.StatusGatewayTimeout {
+			t.Errorf("%s: status = %d, want %d", c.name, w.Code, http.StatusGatewayTimeout)
+		}
+		if !strings.Contains(w.Body.String(), `"error_desc":"DeadlineExceeded"`) {
+			t.Errorf("%s: body = %s", c.name, w.Body.String())
+		}
+		if left := <-deadlines; left > c.want {
+			t.Errorf("%s: handler deadline in %v, want at most %v", c.name, left, c.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	gingrpc "github.com/dan-and-dna/gin-grpc"
+	"github.com/dan-and-dna/gin-grpc-network/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// 请求的类型和注册的类型不一致
+type TypeMismatchError struct {
+	Key  string // 完整的方法名
+	Want string // 注册的请求类型
+	Got  string // 收到的请求类型
+}
+
+func (err *TypeMismatchError) Error() string {
+	return fmt.Sprintf("%s: want request %s, got %s", err.Key, err.Want, err.Got)
+}
+
+func (err *TypeMismatchError) GRPCStatus() *status.Status {
+	return status.New(codes.InvalidArgument, err.Error())
+}
+
+// 带类型的 HandleProto，http和grpc都由它处理
+func Handle[Req, Resp proto.Message](network *Network, pkg, service, method string, desc *grpc.ServiceDesc, handler func(context.Context, Req) (Resp, error), middlewares ...grpc.UnaryServerInterceptor) error {
+	var zero Req
+	var zeroResp Resp
+	prototype := zero.ProtoReflect().Type().New().Interface()
+	key := utils.MakeFullMethod(pkg, service, method)
+
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	return network.register(&registration{
+		pkg:         pkg,
+		service:     service,
+		method:      method,
+		desc:        desc,
+		middlewares: middlewares,
+		respType:    string(zeroResp.ProtoReflect().Descriptor().FullName()),
+		handler: &gingrpc.Handler{
+			Proto: prototype,
+			HandleProto: func(ctx context.Context, rawReq interface{}) (interface{}, error) {
+				req, ok := rawReq.(Req)
+				if !ok {
+					return nil, &TypeMismatchError{Key: key, Want: fmt.Sprintf("%T", zero), Got: fmt.Sprintf("%T", rawReq)}
+				}
+
+				resp, err := handler(ctx, req)
+				if err != nil {
+					return nil, err
+				}
+
+				// 带类型的空指针grpc编码不了，按空的回复处理
+				if !resp.ProtoReflect().IsValid() {
+					return zeroResp.ProtoReflect().Type().New().Interface(), nil
+				}
+				return resp, nil
+			},
+		},
+	})
+}
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"github.com/dan-and-dna/gin-grpc-network/core"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleTypeMismatch(t *testing.T) {
+	network := newTestNetwork()
+	err := Handle(network, "grpc.health.v1", "Health", "Check", nil, func(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+		return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := network.Invoke(context.Background(), "grpc.health.v1", "Health", "Check", &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.(*grpc_health_v1.HealthCheckResponse).Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("resp = %v", resp)
+	}
+
+	_, err = network.Invoke(context.Background(), "grpc.health.v1", "Health", "Check", &grpc_health_v1.HealthCheckResponse{})
+	var mismatch *TypeMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("err = %v, want TypeMismatchError", err)
+	}
+	if mismatch.Key != "/grpc.health.v1.Health/Check" || mismatch.Want != "*grpc_health_v1.HealthCheckRequest" || mismatch.Got != "*grpc_health_v1.HealthCheckResponse" {
+		t.Fatalf("mismatch = %+v", mismatch)
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("code = %v", status.Code(err))
+	}
+}
+
+func TestHandleTypedNilResponse(t *testing.T) {
+	network := newTestNetwork()
+	err := Handle(network, "grpc.health.v1", "Health", "Check", nil, func(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newTestRouter(t, network)
+
+	// encoding/json 和 protojson 都返回 {}
+	for _, options := range []*core.JsonOptions{nil, {}} {
+		network.jsonCodec.Store(newJsonCodec(options, nil))
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/grpc.health.v1/Health/Check", strings.NewReader(`{}`))
+		r.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != "{}" {
+			t.Fatalf("json options %v: %d %s", options, w.Code, w.Body.String())
+		}
+	}
+
+	resp, err := grpc_health_v1.NewHealthClient(startTestGrpc(t, network)).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != grpc_health_v1.HealthCheckResponse_UNKNOWN {
+		t.Fatalf("grpc: %v", resp)
+	}
+}
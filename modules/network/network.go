@@ -1,32 +1,202 @@
 package network
 
 import (
+	"context"
+	"encoding/json"
 	gingrpc "github.com/dan-and-dna/gin-grpc"
+	"github.com/dan-and-dna/gin-grpc-network/core"
 	"github.com/dan-and-dna/gin-grpc-network/modules/network/internal"
 	"github.com/dan-and-dna/singleinstmodule"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
 	"testing"
 )
 
 type Network = internal.Network
 type Bench = internal.Bench
+type TypeMismatchError = internal.TypeMismatchError
+type Route = internal.Route
+type Event = internal.Event
+type DynamicHandler = internal.DynamicHandler
+type MirrorOption = internal.MirrorOption
+type RemoteOption = internal.RemoteOption
+type MirrorDiff = internal.MirrorDiff
+type CanaryRule = internal.CanaryRule
+type CodeMsgEnvelope = internal.CodeMsgEnvelope
+type SubscribeOption = internal.SubscribeOption
+type Subscription = internal.Subscription
+type OverflowPolicy = internal.OverflowPolicy
 
-func ListenProto(pkg, service, method string, desc *grpc.ServiceDesc, listener func(grpc.ServerStream) error) {
-	internal.GetSingleInst().ListenProto(pkg, service, method, desc, listener)
+// 订阅队列满时的策略
+const (
+	DropNewest = internal.DropNewest
+	DropOldest = internal.DropOldest
+	Block      = internal.Block
+)
+
+// 注册失败的原因
+var (
+	ErrBadRegister       = internal.ErrBadRegister
+	ErrDuplicateRegister = internal.ErrDuplicateRegister
+	ErrRegisterConflict  = internal.ErrRegisterConflict
+	ErrMethodNotInDesc   = internal.ErrMethodNotInDesc
+)
+
+const RpcMethodHeader = internal.RpcMethodHeader
+
+// 内置的http路径解析方式
+var (
+	PathToServiceByPath     = internal.PathToServiceByPath
+	PathToServiceByGrpcPath = internal.PathToServiceByGrpcPath
+	PathToServiceByHeader   = internal.PathToServiceByHeader
+)
+
+// 网络层单例
+func GetSingleInst() *Network {
+	return internal.GetSingleInst()
+}
+
+// 以接口的形式返回网络层单例，业务依赖接口，测试时可以换成假的实现
+func Default() core.Network {
+	return internal.GetSingleInst()
+}
+
+func GetConfig() *core.NetworkCore {
+	return internal.GetSingleInst().GetConfig()
+}
+
+func UpdateCfg(cfg *core.NetworkCore) error {
+	return internal.GetSingleInst().UpdateCfg(cfg)
+}
+
+func ReStart() {
+	internal.GetSingleInst().ReStart()
+}
+
+func AddProtoListener(pkg, service, method string, listener core.ProtoListener) {
+	internal.GetSingleInst().AddProtoListener(pkg, service, method, listener)
+}
+
+func Subscribe(pkg, service, method string, option SubscribeOption, observer func(Event)) *Subscription {
+	return internal.GetSingleInst().Subscribe(pkg, service, method, option, observer)
+}
+
+func RemoveProtoListeners(pkg, service, method string) {
+	internal.GetSingleInst().RemoveProtoListeners(pkg, service, method)
+}
+
+func NotifyListeners(ctx context.Context, req interface{}, key string) {
+	internal.GetSingleInst().NotifyListeners(ctx, req, key)
+}
+
+func NotifyHandler(ctx context.Context, req interface{}, key string) (interface{}, error) {
+	return internal.GetSingleInst().NotifyHandler(ctx, req, key)
+}
+
+// 在进程内调用处理者，经过和grpc请求一样的中间件，但不经过序列化和网络
+func Invoke(ctx context.Context, pkg, service, method string, req interface{}) (interface{}, error) {
+	return internal.GetSingleInst().Invoke(ctx, pkg, service, method, req)
+}
+
+func ListenProto(pkg, service, method string, desc *grpc.ServiceDesc, listener func(grpc.ServerStream) error, middlewares ...grpc.StreamServerInterceptor) error {
+	return internal.GetSingleInst().ListenProto(pkg, service, method, desc, listener, middlewares...)
 }
 
 func StopListenProto(pkg, service, method string) {
 	internal.GetSingleInst().StopListenProto(pkg, service, method)
 }
 
-func HandleProto(pkg, service, method string, desc *grpc.ServiceDesc, handler gingrpc.Handler) {
-	internal.GetSingleInst().HandleProto(pkg, service, method, desc, handler)
+func HandleProto(pkg, service, method string, desc *grpc.ServiceDesc, handler gingrpc.Handler, middlewares ...grpc.UnaryServerInterceptor) error {
+	return internal.GetSingleInst().HandleProto(pkg, service, method, desc, handler, middlewares...)
+}
+
+// 在服务上挂中间件，http和grpc都生效
+func UseService(pkg, service string, middlewares ...grpc.UnaryServerInterceptor) {
+	internal.GetSingleInst().UseService(pkg, service, middlewares...)
+}
+
+func UseServiceStream(pkg, service string, middlewares ...grpc.StreamServerInterceptor) {
+	internal.GetSingleInst().UseServiceStream(pkg, service, middlewares...)
+}
+
+// 带类型的 HandleProto，不需要再对请求做类型断言
+func Handle[Req, Resp proto.Message](pkg, service, method string, desc *grpc.ServiceDesc, handler func(context.Context, Req) (Resp, error), middlewares ...grpc.UnaryServerInterceptor) error {
+	return internal.Handle(internal.GetSingleInst(), pkg, service, method, desc, handler, middlewares...)
+}
+
+func RegisterService(desc *grpc.ServiceDesc, impl interface{}) error {
+	return internal.GetSingleInst().RegisterService(desc, impl)
+}
+
+// 注册动态处理者，files 由 LoadFileDescriptorSet 或者 LoadProtoFiles 加载
+func HandleDynamic(files *protoregistry.Files, pkg, service, method string, handler DynamicHandler, middlewares ...grpc.UnaryServerInterceptor) error {
+	return internal.GetSingleInst().HandleDynamic(files, pkg, service, method, handler, middlewares...)
+}
+
+func LoadFileDescriptorSet(data []byte) (*protoregistry.Files, error) {
+	return internal.LoadFileDescriptorSet(data)
+}
+
+func LoadProtoFiles(ctx context.Context, importPaths []string, files ...string) (*protoregistry.Files, error) {
+	return internal.LoadProtoFiles(ctx, importPaths, files...)
+}
+
+// 错误的 details 按json编码，自定义 core.Envelope 时使用
+func StatusDetails(s *status.Status) []json.RawMessage {
+	return internal.StatusDetails(s)
+}
+
+// 方法转发给远程的grpc服务，请求和回复的类型按 pkg.service 的描述查找
+func HandleRemote(pkg, service, method, target string, option RemoteOption) error {
+	return internal.GetSingleInst().HandleRemote(pkg, service, method, target, option)
+}
+
+func HandleRemoteService(serviceName, target string, option RemoteOption) error {
+	return internal.GetSingleInst().HandleRemoteService(serviceName, target, option)
+}
+
+func StopRemoteService(serviceName string) {
+	internal.GetSingleInst().StopRemoteService(serviceName)
+}
+
+// 给方法加上镜像，请求的副本异步交给影子处理者或者影子grpc服务，结果不一致时记录
+func Mirror(pkg, service, method string, option MirrorOption) error {
+	return internal.GetSingleInst().Mirror(pkg, service, method, option)
+}
+
+func StopMirror(pkg, service, method string) {
+	internal.GetSingleInst().StopMirror(pkg, service, method)
+}
+
+// 注册方法的另一个版本，按 SetCanaryRules 设置的规则灰度
+func HandleProtoVersion(pkg, service, method, version string, handler gingrpc.Handler) error {
+	return internal.GetSingleInst().HandleProtoVersion(pkg, service, method, version, handler)
+}
+
+func StopHandleProtoVersion(pkg, service, method, version string) {
+	internal.GetSingleInst().StopHandleProtoVersion(pkg, service, method, version)
+}
+
+func SetCanaryRules(pkg, service, method string, rules ...CanaryRule) error {
+	return internal.GetSingleInst().SetCanaryRules(pkg, service, method, rules...)
 }
 
 func StopHandleProto(pkg, service, method string) {
 	internal.GetSingleInst().StopHandleProto(pkg, service, method)
 }
 
+// 列出所有已注册的方法
+func Routes() []Route {
+	return internal.GetSingleInst().Routes()
+}
+
+// 只差大小写的方法名，切换到区分大小写的模式前需要确认
+func CaseCollisions() [][]string {
+	return internal.GetSingleInst().CaseCollisions()
+}
+
 func ModuleLock() singleinstmodule.ModuleCore {
 	return internal.GetSingleInst().ModuleLock()
 }
@@ -6,3 +6,21 @@ import "strings"
 func MakeKey(pkg, service, method string) string {
 	return strings.ToLower("/" + pkg + "." + service + "/" + method)
 }
+
+// 创建grpc的完整方法名，保留大小写
+func MakeFullMethod(pkg, service, method string) string {
+	return "/" + pkg + "." + service + "/" + method
+}
+
+// 创建grpc服务的前缀，用来匹配服务下的所有方法
+func MakeServiceKey(pkg, service string) string {
+	return "/" + pkg + "." + service + "/"
+}
+
+// 按路由模式规范化完整方法名，不区分大小写时统一转成小写
+func NormalizeKey(fullMethod string, caseSensitive bool) string {
+	if caseSensitive {
+		return fullMethod
+	}
+	return strings.ToLower(fullMethod)
+}